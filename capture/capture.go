@@ -0,0 +1,96 @@
+package capture
+
+import "time"
+
+// Link-layer header types of captured packets.
+//
+// https://www.tcpdump.org/linktypes.html
+const (
+	LinkTypeNull      = 0   // BSD loopback encapsulation.
+	LinkTypeEthernet  = 1   // IEEE 802.3 Ethernet.
+	LinkTypeRaw       = 101 // Raw IPv4 or IPv6 packets without link-layer header.
+	LinkTypeLinuxSLL  = 113 // Linux cooked capture.
+	LinkTypeIPv4      = 228 // Raw IPv4 packets.
+	LinkTypeIPv6      = 229 // Raw IPv6 packets.
+	LinkTypeLinuxSLL2 = 276 // Linux cooked capture v2.
+)
+
+// Info contains metadata of a captured packet.
+type Info struct {
+	Timestamp   time.Time  // The time the packet was captured.
+	InterfaceID int        // The index of the capture interface in the list of interfaces (pcapng interface ID).
+	Length      int        // The original length of the packet on the wire, 0 if unknown.
+	LinkType    int        // The link-layer header type of the packet data.
+	PacketType  PacketType // Whether the packet was received or sent by the capturing host.
+	Comment     string     // Comment attached to the packet, empty if there is none.
+	Previous    time.Time  // The time the previous packet was captured, zero for the first packet.
+}
+
+// OriginalLength returns the original length of the packet with the given captured data.
+//
+// If the length is unknown, the packet is assumed not to be truncated.
+func (ci Info) OriginalLength(data []byte) int {
+	return max(ci.Length, len(data))
+}
+
+// Truncated reports whether the captured data is shorter than the original packet.
+func (ci Info) Truncated(data []byte) bool {
+	return ci.Length > len(data)
+}
+
+// Stats contains capture statistics of an interface.
+type Stats struct {
+	InterfaceID int       // The index of the capture interface in the list of interfaces (pcapng interface ID).
+	StartTime   time.Time // The time the capture started.
+	EndTime     time.Time // The time the statistics were taken.
+	Received    uint64    // The number of packets received by the kernel, including dropped ones.
+	Dropped     uint64    // The number of packets dropped by the kernel because of lack of buffer space.
+}
+
+// PacketType describes the direction of a packet relative to the capturing host
+// and whom it was addressed to.
+type PacketType uint8
+
+const (
+	PacketTypeUnknown   PacketType = iota // The direction is not known, e.g. for packets read from pcap files.
+	PacketTypeHost                        // Unicast packet addressed to the capturing host.
+	PacketTypeBroadcast                   // Broadcast packet.
+	PacketTypeMulticast                   // Multicast packet.
+	PacketTypeOtherHost                   // Unicast packet addressed to another host, seen in promiscuous mode.
+	PacketTypeOutgoing                    // Packet sent by the capturing host.
+)
+
+// Inbound reports whether the packet was received by the capturing host.
+func (pt PacketType) Inbound() bool {
+	return pt >= PacketTypeHost && pt <= PacketTypeOtherHost
+}
+
+// Outbound reports whether the packet was sent by the capturing host.
+func (pt PacketType) Outbound() bool {
+	return pt == PacketTypeOutgoing
+}
+
+func (pt PacketType) String() string {
+	switch pt {
+	case PacketTypeHost:
+		return "Inbound"
+	case PacketTypeBroadcast:
+		return "Inbound (broadcast)"
+	case PacketTypeMulticast:
+		return "Inbound (multicast)"
+	case PacketTypeOtherHost:
+		return "Inbound (to another host)"
+	case PacketTypeOutgoing:
+		return "Outbound"
+	default:
+		return "Unknown"
+	}
+}
+
+// Precision is the resolution of timestamps written to capture files.
+type Precision int
+
+const (
+	Microsecond Precision = iota // The default resolution of pcap files.
+	Nanosecond
+)
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"slices"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
-	ms "github.com/shadowy-pycoder/mshark"
-	"github.com/shadowy-pycoder/mshark/mpcap"
-	"github.com/shadowy-pycoder/mshark/mpcapng"
+	ms "github.com/shadowy-pycoder/mshark/v2"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+	"github.com/shadowy-pycoder/mshark/v2/mpcap"
+	"github.com/shadowy-pycoder/mshark/v2/mpcapng"
 )
 
 const app string = "mshark"
@@ -38,7 +47,14 @@ Options:
   -h    Show this help message and exit.
 `
 
-var supportedFormats = []string{"stdout", "txt", "pcap", "pcapng"}
+var supportedFormats = []string{"stdout", "txt", "pcap", "pcapng", "json", "csv", "tsv", "pdml", "psml"}
+
+// defaultFields are fields written to csv and tsv files when no fields are given with -F.
+var defaultFields = []string{
+	"frame.number", "frame.time", "frame.len", "frame.protocols",
+	"ip.src", "ip.dst", "ipv6.src", "ipv6.dst",
+	"tcp.srcport", "tcp.dstport", "udp.srcport", "udp.dstport",
+}
 
 var (
 	_ ms.PacketWriter = &mpcap.Writer{}
@@ -62,6 +78,40 @@ func (f *ExtFlag) UnmarshalText(b []byte) error {
 	return nil
 }
 
+type IfaceFlag []string
+
+func (f *IfaceFlag) MarshalText() ([]byte, error) {
+	return nil, nil
+}
+
+func (f *IfaceFlag) UnmarshalText(b []byte) error {
+	ifaces := *f
+	for _, iface := range strings.Split(string(b), ",") {
+		if iface != "" && !slices.Contains(ifaces, iface) {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	*f = ifaces
+	return nil
+}
+
+type FieldFlag []string
+
+func (f *FieldFlag) MarshalText() ([]byte, error) {
+	return nil, nil
+}
+
+func (f *FieldFlag) UnmarshalText(b []byte) error {
+	fields := *f
+	for _, field := range strings.Split(string(b), ",") {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	*f = fields
+	return nil
+}
+
 func displayInterfaces() error {
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 0, 2, ' ', tabwriter.TabIndent)
@@ -77,20 +127,248 @@ func displayInterfaces() error {
 	return w.Flush()
 }
 
-func createFile(app, ext string) (*os.File, error) {
-	path := fmt.Sprintf("./%s_%s.%s", app, time.Now().UTC().Format("20060102_150405"), ext)
-	f, err := os.OpenFile(filepath.FromSlash(path), os.O_CREATE|os.O_WRONLY, 0644)
+const (
+	defaultTemplate       string = app + "_{time}.{ext}"
+	defaultRotateTemplate string = app + "_{time}_{seq}.{ext}"
+)
+
+// outputFiles describes where output files are created and how they are named.
+//
+// The name template may contain the following placeholders:
+//
+//   - {iface} names of the capture interfaces joined with "_"
+//   - {time} the time the file is created, e.g. 20240917_093750
+//   - {seq} the sequence number of the file when files are rotated, e.g. 00001
+//   - {ext} the file extension of the output format
+type outputFiles struct {
+	dir       string
+	template  string
+	ifaces    string
+	overwrite bool
+	ext       string // the extension of the compression added to file names
+}
+
+// name returns the path of the file with the given format and sequence number.
+// The extension of the compression is added to the end of the name.
+func (o *outputFiles) name(ext string, seq int, t time.Time) string {
+	r := strings.NewReplacer(
+		"{iface}", o.ifaces,
+		"{time}", t.UTC().Format("20060102_150405"),
+		"{seq}", fmt.Sprintf("%05d", seq),
+		"{ext}", ext,
+	)
+	return filepath.Join(o.dir, filepath.FromSlash(r.Replace(o.template))) + o.ext
+}
+
+// create creates a new output file. Existing files are truncated if overwriting is enabled,
+// otherwise an error is returned.
+func (o *outputFiles) create(ext string, seq int) (*os.File, error) {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	if o.overwrite {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	f, err := os.OpenFile(o.name(ext, seq, time.Now()), flag, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %v", err)
 	}
 	return f, nil
 }
 
+// textWriter creates a PacketWriter displaying packets on stdout or in txt files
+// with the header already written.
+type textWriter func(w io.Writer, conf *ms.Config) (ms.PacketWriter, error)
+
+// newTextWriter returns a textWriter displaying packets in full (verbose), as a table
+// with a single line per packet (summary) or as summaries of layers otherwise.
+// Timestamps of packets are displayed in the given format, and with hexDump
+// whole packets are dumped in hex and ASCII.
+func newTextWriter(verbose, summary, hexDump bool, timestamps ms.TimestampFormat) textWriter {
+	if summary {
+		return func(w io.Writer, conf *ms.Config) (ms.PacketWriter, error) {
+			sw := ms.NewSummaryWriter(w)
+			sw.SetTimestampFormat(timestamps)
+			sw.SetHexDump(hexDump)
+			return sw, sw.WriteHeader()
+		}
+	}
+	return func(w io.Writer, conf *ms.Config) (ms.PacketWriter, error) {
+		mw := ms.NewWriter(w, verbose)
+		mw.SetTimestampFormat(timestamps)
+		mw.SetHexDump(hexDump)
+		return mw, mw.WriteHeader(conf)
+	}
+}
+
+// fileWriter returns a function creating a PacketWriter of the given format
+// with the header already written.
+//
+// txt files are created by text.
+// linkTypes are link types of the configured devices, pcap files take the first of them.
+// With resolveNames, pcapng files get host names learned from DNS answers.
+// If keyLog is not nil, its contents are embedded into pcapng files.
+// Fields are columns of csv and tsv files.
+func fileWriter(ext string, conf *ms.Config, linkTypes []int, text textWriter, precision capture.Precision, resolveNames bool, keyLog io.ReaderAt, fields []string) func(w io.Writer) (ms.PacketWriter, error) {
+	switch ext {
+	case "txt":
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			return text(w, conf)
+		}
+	case "json":
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			return ms.NewJSONWriter(w), nil
+		}
+	case "pdml":
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			pw := ms.NewPDMLWriter(w)
+			return pw, pw.WriteHeader(app)
+		}
+	case "psml":
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			pw := ms.NewPSMLWriter(w)
+			return pw, pw.WriteHeader(app)
+		}
+	case "csv", "tsv":
+		comma := ','
+		if ext == "tsv" {
+			comma = '\t'
+		}
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			fw, err := ms.NewFieldsWriter(w, fields, comma)
+			if err != nil {
+				return nil, err
+			}
+			return fw, fw.WriteHeader()
+		}
+	case "pcap":
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			pw := mpcap.NewWriterPrecision(w, precision)
+			return pw, pw.WriteHeaderLinkType(conf.Snaplen, uint32(linkTypes[0]))
+		}
+	case "pcapng":
+		lts := make([]uint16, len(linkTypes))
+		for i, lt := range linkTypes {
+			lts[i] = uint16(lt)
+		}
+		return func(w io.Writer) (ms.PacketWriter, error) {
+			pw := mpcapng.NewWriterPrecision(w, precision)
+			if resolveNames {
+				pw.ResolveNames()
+			}
+			if keyLog != nil {
+				pw.EmbedKeyLog(keyLog)
+			}
+			pw.SetComment(conf.Comment)
+			return pw, pw.WriteHeaderLinkTypes(app, conf.Devices, lts, conf.Expr, conf.Snaplen)
+		}
+	default:
+		return nil
+	}
+}
+
+// pcapngReader wraps mpcapng.Reader to number interfaces of all sections of the file.
+//
+// Interface IDs start from zero in every section, so interfaces of a section follow
+// the interfaces of the sections read before it.
+type pcapngReader struct {
+	*mpcapng.Reader
+	section  *mpcapng.Section     // the section of the last packet
+	previous []*mpcapng.Interface // interfaces of the sections before the current one
+}
+
+func (r *pcapngReader) ReadPacket() (capture.Info, []byte, error) {
+	ci, data, err := r.Reader.ReadPacket()
+	if err != nil {
+		return capture.Info{}, nil, err
+	}
+	if s := r.Section(); s != r.section {
+		if r.section != nil {
+			r.previous = append(r.previous, r.section.Interfaces...)
+		}
+		r.section = s
+	}
+	ci.InterfaceID += len(r.previous)
+	return ci, data, nil
+}
+
+// interfaces returns interfaces of all sections read so far.
+func (r *pcapngReader) interfaces() []*mpcapng.Interface {
+	s := r.section
+	if s == nil {
+		s = r.Section()
+	}
+	return slices.Concat(r.previous, s.Interfaces)
+}
+
+// openFile opens a capture file for reading. The format of the file (pcap or pcapng)
+// and compression are detected from its first bytes.
+//
+// It returns the file, the reader of packets and the interfaces packets were captured on.
+// Interfaces of pcapng files are found by reading the whole file before its packets are returned,
+// so that they can be described in outputs before the first packet is written.
+func openFile(path string) (*os.File, ms.PacketReader, []*mpcapng.Interface, error) {
+	f, err := os.Open(filepath.FromSlash(path))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	pr, ins, err := readFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return f, pr, ins, nil
+}
+
+// readFile creates the reader of packets of the capture file and returns it along with the interfaces
+// packets were captured on.
+func readFile(f *os.File) (ms.PacketReader, []*mpcapng.Interface, error) {
+	zr, err := compress.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(zr)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the Section Header Block type is a palindrome, so byte order does not matter
+	if binary.LittleEndian.Uint32(magic) != 0x0a0d0d0a {
+		r, err := mpcap.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, []*mpcapng.Interface{{LinkType: uint16(r.LinkType()), Snaplen: uint32(r.Snaplen())}}, nil
+	}
+	r, err := mpcapng.NewReader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	scan := &pcapngReader{Reader: r}
+	for {
+		if _, _, err := scan.ReadPacket(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	if zr, err = compress.NewReader(f); err != nil {
+		return nil, nil, err
+	}
+	if r, err = mpcapng.NewReader(bufio.NewReader(zr)); err != nil {
+		return nil, nil, err
+	}
+	return &pcapngReader{Reader: r}, scan.interfaces(), nil
+}
+
 func root(args []string) error {
 	conf := ms.Config{}
 
 	flags := flag.NewFlagSet(app, flag.ExitOnError)
-	iface := flags.String("i", "any", "The name of the network interface. Example: eth0")
+	ifaces := IfaceFlag([]string{})
+	flags.TextVar(&ifaces, "i", &ifaces, `The name of the network interface. Can be repeated to capture from several interfaces. Example: -i eth0 -i eth1 (default "any")`)
 	snaplen := flags.Int("s", 0, "The maximum length of each packet snapshot. Defaults to 65535.")
 	flags.BoolFunc("p", `Promiscuous mode. This setting is ignored for "any" interface. Defaults to false.`, func(flagValue string) error {
 		conf.Promisc = true
@@ -99,6 +377,14 @@ func root(args []string) error {
 	flags.DurationVar(&conf.Timeout, "t", 0, "The maximum duration of the packet capture process. Example: 5s")
 	flags.IntVar(&conf.PacketCount, "c", 0, "The maximum number of packets to capture.")
 	flags.StringVar(&conf.Expr, "e", "", `BPF filter expression. Example: "ip proto tcp".`)
+	direction := flags.String("Q", "inout", "Capture packets of the given direction only. Supported directions: in, out, inout")
+	flags.BoolFunc("m", "Capture with memory-mapped TPACKET_V3 ring buffer. Reduces drops on busy links.", func(flagValue string) error {
+		conf.RingBuffer = true
+		return nil
+	})
+	ringSize := flags.Int("B", 0, "The size of the ring buffer in MiB for each interface (with -m). Defaults to 32.")
+	flags.IntVar(&conf.QueueSize, "q", 0, "The maximum number of packets waiting to be written to each output. Defaults to 4096.")
+	flags.StringVar(&conf.File, "r", "", "Read packets from pcap or pcapng file instead of network interface. Example: capture.pcapng")
 	flags.BoolFunc("D", "Display list of interfaces and exit.", func(flagValue string) error {
 		if err := displayInterfaces(); err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %v\n", app, err)
@@ -112,8 +398,50 @@ func root(args []string) error {
 		verbose = true
 		return nil
 	})
+	var summary bool
+	flags.BoolFunc("S", "Display a single line per packet (number, time, addresses, protocol, length and info) when capturing to stdout or txt.", func(flagValue string) error {
+		summary = true
+		return nil
+	})
+	var hexDump bool
+	flags.BoolFunc("x", "Display hex and ASCII dump of every packet after its layers when capturing to stdout or txt. Bytes of layers are highlighted on stdout.", func(flagValue string) error {
+		hexDump = true
+		return nil
+	})
+	timestampFormat := flags.String("timestamp", "", "Timestamp format of packets displayed on stdout or in txt files. Supported formats: seconds, us, ns, relative, delta, delta_displayed, epoch. Defaults to seconds, or relative with -S.")
+	fields := FieldFlag([]string{})
+	flags.TextVar(&fields, "F", &fields, "Field(s) to write to csv and tsv files. Example: -F ip.src -F tcp.dstport -F dns.qry.name (default frame, IP and port fields)")
 	exts := ExtFlag([]string{})
-	flags.TextVar(&exts, "f", &exts, "File extension(s) to write captured data. Supported formats: stdout, txt, pcap, pcapng")
+	flags.TextVar(&exts, "f", &exts, "File extension(s) to write captured data. Supported formats: stdout, txt, pcap, pcapng, json, csv, tsv, pdml, psml")
+	var (
+		rc        ms.RotateConfig
+		fileSize  int64
+		seconds   int
+		precision = capture.Microsecond
+	)
+	flags.Int64Var(&fileSize, "C", 0, "Rotate output files when they reach the given size in megabytes (1,000,000 bytes).")
+	flags.IntVar(&seconds, "G", 0, "Rotate output files every given number of seconds.")
+	flags.IntVar(&rc.PacketCount, "P", 0, "Rotate output files after the given number of packets.")
+	flags.IntVar(&rc.MaxFiles, "W", 0, "The maximum number of rotated files to keep for each format, the oldest files are removed.")
+	var out outputFiles
+	flags.StringVar(&out.dir, "o", ".", "The directory to write output files to. It is created if it does not exist.")
+	flags.StringVar(&out.template, "w", "", `Output file name template with {iface}, {time}, {seq} and {ext} placeholders, or "-" to write a single format other than txt to stdout. Defaults to "mshark_{time}.{ext}" ("mshark_{time}_{seq}.{ext}" with rotation).`)
+	flags.BoolFunc("overwrite", "Overwrite existing output files instead of failing.", func(flagValue string) error {
+		out.overwrite = true
+		return nil
+	})
+	compression := flags.String("z", "none", "Compress output files except stdout. Supported algorithms: none, gzip, zstd")
+	var resolveNames bool
+	flags.BoolFunc("nrb", "Write host names learned from DNS answers to pcapng files as Name Resolution Blocks.", func(flagValue string) error {
+		resolveNames = true
+		return nil
+	})
+	flags.StringVar(&conf.Comment, "comment", "", `Comment describing the capture written to pcapng files. Example: "incident #42, host is unreachable"`)
+	keyLogFile := flags.String("keylog", "", "Embed TLS key log file (SSLKEYLOGFILE) into pcapng files as Decryption Secrets Blocks, including keys logged during the capture.")
+	flags.BoolFunc("nano", "Write timestamps with nanosecond resolution to pcap and pcapng files. Defaults to microseconds.", func(flagValue string) error {
+		precision = capture.Nanosecond
+		return nil
+	})
 
 	flags.Usage = func() {
 		fmt.Print(usagePrefix)
@@ -124,76 +452,211 @@ func root(args []string) error {
 		return err
 	}
 
-	// getting network interface from the provided name
-	in, err := ms.InterfaceByName(*iface)
+	var (
+		pr        ms.PacketReader
+		linkTypes []int
+		err       error
+	)
+	if conf.File != "" {
+		// offline capture, interface is only used to describe the source of packets
+		f, r, ins, err := openFile(conf.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		pr = r
+		if len(ins) == 0 {
+			ins = append(ins, &mpcapng.Interface{LinkType: capture.LinkTypeEthernet})
+		}
+		for i, in := range ins {
+			name := in.Name
+			if name == "" {
+				name = filepath.Base(conf.File)
+			}
+			conf.Devices = append(conf.Devices, &net.Interface{Index: i, Name: name})
+			linkTypes = append(linkTypes, int(in.LinkType))
+			conf.Snaplen = max(conf.Snaplen, int(in.Snaplen))
+		}
+		if conf.Snaplen <= 0 {
+			conf.Snaplen = 262144
+		}
+	} else {
+		// getting network interfaces from the provided names
+		if len(ifaces) == 0 {
+			ifaces = append(ifaces, "any")
+		}
+		if len(ifaces) > 1 && slices.Contains(ifaces, "any") {
+			return fmt.Errorf(`"any" interface can not be combined with other interfaces`)
+		}
+		for _, iface := range ifaces {
+			in, err := ms.InterfaceByName(iface)
+			if err != nil {
+				return err
+			}
+			linkType, err := ms.LinkType(in)
+			if err != nil {
+				return err
+			}
+			conf.Devices = append(conf.Devices, in)
+			linkTypes = append(linkTypes, linkType)
+		}
+
+		// checking snaplen
+		if *snaplen <= 0 || *snaplen > 65535 {
+			*snaplen = 65535
+		}
+		conf.Snaplen = *snaplen
+
+		// checking ring buffer size
+		if *ringSize > 0 {
+			conf.RingSize = *ringSize << 20
+		}
+	}
+
+	if verbose && summary {
+		return fmt.Errorf("-v and -S can not be used together")
+	}
+	timestamps := ms.TimestampSeconds
+	if summary {
+		timestamps = ms.TimestampRelative
+	}
+	if *timestampFormat != "" {
+		timestamps, err = ms.ParseTimestampFormat(*timestampFormat)
+		if err != nil {
+			return err
+		}
+	}
+
+	conf.Direction, err = ms.ParseDirection(*direction)
 	if err != nil {
 		return err
 	}
-	conf.Device = in
 
-	// checking snaplen
-	if *snaplen <= 0 || *snaplen > 65535 {
-		*snaplen = 65535
+	// rotating files
+	if fileSize > 0 {
+		rc.FileSize = fileSize * 1e6
+	}
+	if seconds > 0 {
+		rc.Interval = time.Duration(seconds) * time.Second
+	}
+
+	// checking output files
+	rc.Compression, err = compress.ParseAlgorithm(*compression)
+	if err != nil {
+		return err
+	}
+	out.ext = rc.Compression.Ext()
+	if len(exts) == 0 {
+		exts = append(exts, "stdout")
+	}
+	var files []string
+	for _, ext := range exts {
+		if ext != "stdout" {
+			files = append(files, ext)
+		}
+	}
+	streaming := out.template == "-"
+	switch {
+	case streaming:
+		if len(files) != 1 || len(exts) != 1 || files[0] == "txt" {
+			return fmt.Errorf(`"-w -" requires exactly one format other than stdout and txt`)
+		}
+		if rc.Enabled() {
+			return fmt.Errorf(`"-w -" can not be combined with file rotation`)
+		}
+		// keeping stdout clean for packet data
+		conf.StatsOutput = os.Stderr
+	case out.template == "":
+		out.template = defaultTemplate
+		if rc.Enabled() {
+			out.template = defaultRotateTemplate
+		}
+	default:
+		if rc.Enabled() && !strings.Contains(out.template, "{seq}") {
+			return fmt.Errorf("file name template must contain {seq} when rotating files")
+		}
+		if len(files) > 1 && !strings.Contains(out.template, "{ext}") {
+			return fmt.Errorf("file name template must contain {ext} when writing several formats")
+		}
+	}
+	if len(files) > 0 && !streaming {
+		if err := os.MkdirAll(out.dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
+	names := make([]string, len(conf.Devices))
+	for i, in := range conf.Devices {
+		names[i] = in.Name
+	}
+	out.ifaces = strings.Join(names, "_")
+
+	// checking fields of tabular formats
+	if len(fields) > 0 && !slices.Contains(files, "csv") && !slices.Contains(files, "tsv") {
+		return fmt.Errorf("-F requires csv or tsv format")
+	}
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+	if _, err := ms.NewFieldsWriter(io.Discard, fields, ','); err != nil {
+		return err
+	}
+
+	// embedding TLS secrets
+	var keyLog io.ReaderAt
+	if *keyLogFile != "" {
+		if !slices.Contains(files, "pcapng") {
+			return fmt.Errorf("-keylog requires pcapng format")
+		}
+		f, err := os.Open(*keyLogFile)
+		if err != nil {
+			return fmt.Errorf("failed to open key log file: %v", err)
+		}
+		defer f.Close()
+		keyLog = f
 	}
-	conf.Snaplen = *snaplen
 
 	// creating writers and writing headers depending on a file extension
+	text := newTextWriter(verbose, summary, hexDump, timestamps)
 	var pw []ms.PacketWriter
-	if len(exts) != 0 {
-		for _, ext := range exts {
-			switch ext {
-			case "stdout":
-				w := ms.NewWriter(os.Stdout, verbose)
-				if err := w.WriteHeader(&conf); err != nil {
-					return err
-				}
-				pw = append(pw, w)
-			case "txt":
-				f, err := createFile(app, ext)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-				w := ms.NewWriter(f, verbose)
-				if err := w.WriteHeader(&conf); err != nil {
-					return err
-				}
-				pw = append(pw, w)
-			case "pcap":
-				f, err := createFile(app, ext)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-				w := mpcap.NewWriter(f)
-				if err := w.WriteHeader(conf.Snaplen); err != nil {
-					return err
-				}
-				pw = append(pw, w)
-			case "pcapng":
-				f, err := createFile(app, ext)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-				w := mpcapng.NewWriter(f)
-				if err := w.WriteHeader(app, conf.Device, conf.Expr, conf.Snaplen); err != nil {
-					return err
-				}
-				pw = append(pw, w)
-			default:
-				// unreachable
-				return fmt.Errorf("unsupported file format: %s", ext)
+	for _, ext := range exts {
+		if ext == "stdout" {
+			w, err := text(os.Stdout, &conf)
+			if err != nil {
+				return err
 			}
+			pw = append(pw, w)
+			continue
 		}
-	} else {
-		w := ms.NewWriter(os.Stdout, verbose)
-		if err := w.WriteHeader(&conf); err != nil {
+		if ext == "pcap" && slices.ContainsFunc(linkTypes, func(lt int) bool { return lt != linkTypes[0] }) {
+			return fmt.Errorf("pcap format does not support interfaces with different link types, use pcapng instead")
+		}
+		newWriter := fileWriter(ext, &conf, linkTypes, text, precision, resolveNames, keyLog, fields)
+		if newWriter == nil {
+			// unreachable
+			return fmt.Errorf("unsupported file format: %s", ext)
+		}
+		create := func(seq int) (*os.File, error) {
+			if streaming {
+				return os.Stdout, nil
+			}
+			return out.create(ext, seq)
+		}
+		w, err := ms.NewRotator(&rc, create, newWriter)
+		if err != nil {
 			return err
 		}
+		defer w.Close()
 		pw = append(pw, w)
 	}
-	if err := ms.OpenLive(&conf, pw...); err != nil {
+	// stopping capture on interrupt, so that statistics are printed and files are closed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// restoring default behavior after the first signal, so that the second one kills a stuck shutdown
+	context.AfterFunc(ctx, stop)
+	if pr != nil {
+		return ms.OpenOfflineContext(ctx, &conf, pr, pw...)
+	}
+	if err := ms.OpenLiveContext(ctx, &conf, pw...); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/mpcapng"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPcapngInterfaces(t *testing.T) {
+	eth, err := os.ReadFile("../../layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("../../layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two sections, the first one with interfaces of different link types
+	var buf bytes.Buffer
+	ts := time.Unix(1726565870, 0).UTC()
+	w := mpcapng.NewWriter(&buf)
+	ins := []*net.Interface{{Index: 1, Name: "eth0"}, {Index: 2, Name: "tun0"}}
+	if err := w.WriteHeaderLinkTypes(app, ins, []uint16{capture.LinkTypeEthernet, capture.LinkTypeRaw}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{Timestamp: ts, InterfaceID: 1, LinkType: capture.LinkTypeRaw}, ipv4); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{Timestamp: ts, InterfaceID: 0, LinkType: capture.LinkTypeEthernet}, eth); err != nil {
+		t.Fatal(err)
+	}
+	w = mpcapng.NewWriter(&buf)
+	if err := w.WriteHeader(app, []*net.Interface{{Index: 3, Name: "eth1"}}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{Timestamp: ts, LinkType: capture.LinkTypeEthernet}, eth); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in.pcapng")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := root([]string{"-r", path, "-f", "pcapng", "-o", out, "-w", "out.pcapng"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(out, "out.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := mpcapng.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ids       []int
+		linkTypes []int
+	)
+	for {
+		ci, _, err := r.ReadPacket()
+		if err != nil {
+			break
+		}
+		ids = append(ids, ci.InterfaceID)
+		linkTypes = append(linkTypes, ci.LinkType)
+	}
+	require.Equal(t, []int{1, 0, 2}, ids)
+	require.Equal(t, []int{capture.LinkTypeRaw, capture.LinkTypeEthernet, capture.LinkTypeEthernet}, linkTypes)
+	var names []string
+	for _, in := range r.Section().Interfaces {
+		names = append(names, in.Name)
+	}
+	require.Equal(t, []string{"eth0", "tun0", "eth1"}, names)
+}
@@ -0,0 +1,126 @@
+// Package compress provides compression of capture files.
+//
+// Compressed files are detected by their magic numbers, so readers
+// do not need to know how a file was written.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm.
+type Algorithm int
+
+const (
+	None Algorithm = iota
+	Gzip
+	Zstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ParseAlgorithm returns the algorithm with the given name: none, gzip or zstd.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "", "none":
+		return None, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return None, fmt.Errorf("unsupported compression: %s", name)
+	}
+}
+
+// String returns the name of the algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// Ext returns the file extension of the algorithm including the leading dot,
+// or an empty string if there is no compression.
+func (a Algorithm) Ext() string {
+	switch a {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// NewWriter returns a writer compressing data written to w.
+//
+// Closing the returned writer flushes compressed data, but does not close w.
+// With None, data is written to w as is.
+func NewWriter(w io.Writer, a Algorithm) (io.WriteCloser, error) {
+	switch a {
+	case None:
+		return nopCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %v", err)
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %d", a)
+	}
+}
+
+// NewReader returns a reader decompressing data read from r.
+//
+// The compression algorithm is detected from the first bytes of data,
+// uncompressed data is returned as is.
+func NewReader(r io.Reader) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %v", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %v", err)
+		}
+		return zr, nil
+	default:
+		return br, nil
+	}
+}
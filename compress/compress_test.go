@@ -0,0 +1,50 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte{0xd4, 0xc3, 0xb2, 0xa1}, 1024)
+	for _, a := range []Algorithm{None, Gzip, Zstd} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if a != None {
+			require.Less(t, buf.Len(), len(data), a.String())
+		}
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, data, got, a.String())
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, a := range []Algorithm{None, Gzip, Zstd} {
+		got, err := ParseAlgorithm(a.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, a, got)
+	}
+	_, err := ParseAlgorithm("lz4")
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package mshark
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+)
+
+// sll2HeaderLen is the length of LINKTYPE_LINUX_SLL2 header.
+//
+// https://www.tcpdump.org/linktypes/LINKTYPE_LINUX_SLL2.html
+const sll2HeaderLen = 20
+
+// putSLL2 writes LINKTYPE_LINUX_SLL2 header describing the packet with the given
+// socket address to b.
+//
+// Packets captured on "any" interface come from devices with different link-layer
+// headers, so the header is replaced with the one that keeps the interface index,
+// packet type and protocol of every packet.
+func putSLL2(b []byte, sa *unix.SockaddrLinklayer) {
+	binary.BigEndian.PutUint16(b[0:2], htons(sa.Protocol))
+	binary.BigEndian.PutUint16(b[2:4], 0) // reserved
+	binary.BigEndian.PutUint32(b[4:8], uint32(sa.Ifindex))
+	binary.BigEndian.PutUint16(b[8:10], sa.Hatype)
+	b[10] = sa.Pkttype
+	b[11] = min(sa.Halen, 8)
+	copy(b[12:20], sa.Addr[:])
+}
+
+// cook replaces the link-layer header of the packet with SLL2 header.
+//
+// The packet of n bytes must be stored in buf after sll2HeaderLen bytes of headroom,
+// netOffset is the offset of the network layer in the packet and length is the original
+// length of the packet. The returned data is truncated to snaplen.
+func cook(buf []byte, n, netOffset, length, snaplen int, sa *unix.SockaddrLinklayer) ([]byte, int) {
+	netOffset = min(netOffset, n)
+	rec := buf[netOffset : sll2HeaderLen+n]
+	putSLL2(rec, sa)
+	return rec[:min(len(rec), snaplen)], length - netOffset + sll2HeaderLen
+}
@@ -0,0 +1,326 @@
+package mshark
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/layers"
+)
+
+var _ PacketWriter = &FieldsWriter{}
+
+// fieldAliases maps Wireshark field names to layer fields whose names differ.
+//
+// Other fields are named after the layer and the path to the struct field, e.g. tcp.dstport
+// or dns.questions.name. Names are case-insensitive.
+var fieldAliases = map[string]string{
+	"eth.src":               "eth.srcmac",
+	"eth.dst":               "eth.dstmac",
+	"eth.type":              "eth.ethertype",
+	"ip.src":                "ipv4.srcip",
+	"ip.dst":                "ipv4.dstip",
+	"ip.len":                "ipv4.totallength",
+	"ip.id":                 "ipv4.identification",
+	"ip.proto":              "ipv4.protocol",
+	"ipv6.src":              "ipv6.srcip",
+	"ipv6.dst":              "ipv6.dstip",
+	"ipv6.plen":             "ipv6.payloadlength",
+	"ipv6.nxt":              "ipv6.nextheader",
+	"ipv6.hlim":             "ipv6.hoplimit",
+	"arp.opcode":            "arp.op",
+	"arp.src.hw_mac":        "arp.sendermac",
+	"arp.src.proto_ipv4":    "arp.senderip",
+	"arp.dst.hw_mac":        "arp.targetmac",
+	"arp.dst.proto_ipv4":    "arp.targetip",
+	"tcp.seq":               "tcp.seqnumber",
+	"tcp.ack":               "tcp.acknumber",
+	"tcp.flags":             "tcp.flags.raw",
+	"tcp.window_size_value": "tcp.windowsize",
+	"tcp.urgent_pointer":    "tcp.urgentpointer",
+	"udp.length":            "udp.udplength",
+	"dns.id":                "dns.transactionid",
+	"dns.flags.response":    "dns.flags.qr",
+	"dns.count.queries":     "dns.qdcount",
+	"dns.count.answers":     "dns.ancount",
+	"dns.qry.name":          "dns.questions.name",
+	"dns.qry.type":          "dns.questions.type.name",
+	"dns.resp.name":         "dns.answerrrs.name",
+	"dns.resp.type":         "dns.answerrrs.type.name",
+	"dns.resp.ttl":          "dns.answerrrs.ttl",
+}
+
+// frameFields are fields describing the packet itself rather than one of its layers.
+var frameFields = []string{
+	"frame.number",       // The number of the packet written by the writer, starting with 1.
+	"frame.time",         // The capture timestamp in RFC 3339 format.
+	"frame.time_epoch",   // The capture timestamp in seconds since the Unix epoch.
+	"frame.len",          // The original length of the packet.
+	"frame.cap_len",      // The captured length of the packet.
+	"frame.interface_id", // The pcapng interface ID of the capture interface.
+	"frame.direction",    // The direction of the packet, empty if unknown.
+	"frame.comment",      // The comment attached to the packet.
+	"frame.protocols",    // Names of decoded layers separated by colons, e.g. eth:ip:udp:dns.
+}
+
+// protoName returns the name of the layer used in field names.
+//
+// Like in Wireshark, IPv4 is called ip, other layers are named in lowercase.
+func protoName(layer string) string {
+	if layer == "IPv4" {
+		return "ip"
+	}
+	return strings.ToLower(layer)
+}
+
+// fieldName returns the name of the field at the path in the layer,
+// using Wireshark field names where they are known.
+func fieldName(layer, path string) string {
+	name := strings.ToLower(layer) + "." + path
+	for alias, target := range fieldAliases {
+		if target == name {
+			return alias
+		}
+	}
+	return protoName(layer) + "." + path
+}
+
+// field is a field selected for FieldsWriter.
+type field struct {
+	name  string   // The name of the field as given by the user.
+	layer string   // The name of the layer in layers.LayerMap, empty for frame fields.
+	path  []string // Lowercase names of nested struct fields.
+}
+
+// parseField resolves the field with the given name to a layer and a path
+// to one of its struct fields.
+func parseField(name string) (field, error) {
+	f := field{name: name}
+	lower := strings.ToLower(name)
+	if slices.Contains(frameFields, lower) {
+		f.path = []string{lower}
+		return f, nil
+	}
+	if alias, ok := fieldAliases[lower]; ok {
+		lower = alias
+	}
+	parts := strings.Split(lower, ".")
+	if len(parts) < 2 {
+		return f, fmt.Errorf("field %q must contain the name of the layer and the name of the field", name)
+	}
+	if parts[0] == "ip" {
+		parts[0] = "ipv4"
+	}
+	for layer := range layers.LayerMap {
+		if strings.ToLower(layer) == parts[0] {
+			f.layer = layer
+		}
+	}
+	if f.layer == "" {
+		return f, fmt.Errorf("unknown layer in field %q", name)
+	}
+	f.path = parts[1:]
+	if !validPath(reflect.TypeOf(layers.LayerMap[f.layer]), f.path) {
+		return f, fmt.Errorf("unknown field %q", name)
+	}
+	return f, nil
+}
+
+// structField returns the exported field of the struct type with the given lowercase name.
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if sf.IsExported() && strings.ToLower(sf.Name) == name {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// validPath reports whether the path refers to a field of the type.
+//
+// Fields of interface types can not be checked until the packet is decoded.
+func validPath(t reflect.Type, path []string) bool {
+	for _, name := range path {
+		for t.Kind() == reflect.Pointer || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Interface:
+			return true
+		case reflect.Struct:
+			sf, ok := structField(t, name)
+			if !ok {
+				return false
+			}
+			t = sf.Type
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fieldValues appends string representations of values found at the path in v.
+//
+// Slices contribute a value for each of their elements.
+func fieldValues(values []string, v reflect.Value, path []string) []string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return values
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := range v.Len() {
+			values = fieldValues(values, v.Index(i), path)
+		}
+		return values
+	}
+	if len(path) == 0 {
+		return append(values, formatValue(v))
+	}
+	if v.Kind() != reflect.Struct {
+		return values
+	}
+	sf, ok := structField(v.Type(), path[0])
+	if !ok {
+		return values
+	}
+	return fieldValues(values, v.FieldByIndex(sf.Index), path[1:])
+}
+
+// formatValue returns the string representation of a field value.
+//
+// Addresses are formatted in their usual notation and byte slices as hex strings.
+func formatValue(v reflect.Value) string {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	if v.CanAddr() {
+		if s, ok := v.Addr().Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	if v.Kind() == reflect.Slice {
+		return hex.EncodeToString(v.Bytes())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// FieldsWriter writes the selected fields of decoded packets as CSV or TSV, one row per packet.
+//
+// Fields are named after Wireshark fields where possible (ip.src, tcp.dstport, dns.qry.name),
+// or after the layer and the path to the field of its struct, e.g. ipv4.ttl or tcp.flags.syn
+// for IPv4Packet.TTL and TCPSegment.Flags.SYN. Frame fields (frame.number, frame.time,
+// frame.len, etc.) describe the packet itself.
+// Fields of layers that are not present in the packet are left empty, and fields
+// with several values, like names of all DNS questions, are separated by commas.
+type FieldsWriter struct {
+	w       *csv.Writer
+	fields  []field
+	packets uint64
+}
+
+// NewFieldsWriter creates a new FieldsWriter writing the given fields separated by comma,
+// e.g. ',' for CSV or '\t' for TSV.
+//
+// An error is returned if one of the fields is unknown.
+func NewFieldsWriter(w io.Writer, fields []string, comma rune) (*FieldsWriter, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields to write")
+	}
+	fw := &FieldsWriter{w: csv.NewWriter(w)}
+	fw.w.Comma = comma
+	for _, name := range fields {
+		f, err := parseField(name)
+		if err != nil {
+			return nil, err
+		}
+		fw.fields = append(fw.fields, f)
+	}
+	return fw, nil
+}
+
+// WriteHeader writes the names of the fields as the first row.
+func (fw *FieldsWriter) WriteHeader() error {
+	names := make([]string, len(fw.fields))
+	for i, f := range fw.fields {
+		names[i] = f.name
+	}
+	return fw.writeRow(names)
+}
+
+// WritePacket decodes the packet and writes the selected fields as a single row.
+func (fw *FieldsWriter) WritePacket(ci capture.Info, data []byte) error {
+	decoded, _, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
+	protocols := make([]string, len(decoded))
+	for i, dl := range decoded {
+		protocols[i] = protoName(dl.name)
+	}
+	fw.packets++
+	row := make([]string, len(fw.fields))
+	var values []string
+	for i, f := range fw.fields {
+		if f.layer == "" {
+			row[i] = frameField(f.path[0], fw.packets, ci, data, protocols)
+			continue
+		}
+		j := slices.IndexFunc(decoded, func(dl decodedLayer) bool { return dl.name == f.layer })
+		if j < 0 {
+			continue
+		}
+		values = fieldValues(values[:0], reflect.ValueOf(decoded[j].layer), f.path)
+		row[i] = strings.Join(values, ",")
+	}
+	return fw.writeRow(row)
+}
+
+// frameField returns the value of the frame field with the given name
+// for the packet with the given number.
+func frameField(name string, num uint64, ci capture.Info, data []byte, protocols []string) string {
+	switch name {
+	case "frame.number":
+		return strconv.FormatUint(num, 10)
+	case "frame.time":
+		return ci.Timestamp.Format(time.RFC3339Nano)
+	case "frame.time_epoch":
+		return fmt.Sprintf("%d.%09d", ci.Timestamp.Unix(), ci.Timestamp.Nanosecond())
+	case "frame.len":
+		return strconv.Itoa(ci.OriginalLength(data))
+	case "frame.cap_len":
+		return strconv.Itoa(len(data))
+	case "frame.interface_id":
+		return strconv.Itoa(ci.InterfaceID)
+	case "frame.direction":
+		if ci.PacketType == capture.PacketTypeUnknown {
+			return ""
+		}
+		return ci.PacketType.String()
+	case "frame.comment":
+		return ci.Comment
+	case "frame.protocols":
+		return strings.Join(protocols, ":")
+	}
+	return ""
+}
+
+// writeRow writes the row and flushes it, so that rows are not held back
+// when packets are rare.
+func (fw *FieldsWriter) writeRow(row []string) error {
+	if err := fw.w.Write(row); err != nil {
+		return err
+	}
+	fw.w.Flush()
+	return fw.w.Error()
+}
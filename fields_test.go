@@ -0,0 +1,50 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldsWriter(t *testing.T) {
+	dns, err := os.ReadFile("layers/testdata/dns.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw IPv4 packet with UDP datagram from port 53
+	packet := []byte{
+		0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x08, 0x08, 0x08, 0x08, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x35, 0xc3, 0x50, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)+len(dns)))
+	binary.BigEndian.PutUint16(packet[24:26], uint16(8+len(dns)))
+	packet = append(packet, dns...)
+
+	var buf bytes.Buffer
+	fields := []string{"frame.number", "frame.time_epoch", "frame.protocols", "ip.src", "IPv4.TTL", "udp.srcport", "tcp.dstport", "dns.qry.name", "dns.qry.type", "dns.flags.rcode"}
+	w, err := NewFieldsWriter(&buf, fields, '\t')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	ci := capture.Info{Timestamp: time.Unix(1726565870, 123456789), LinkType: capture.LinkTypeRaw}
+	if err := w.WritePacket(ci, packet); err != nil {
+		t.Fatal(err)
+	}
+	expected := "frame.number\tframe.time_epoch\tframe.protocols\tip.src\tIPv4.TTL\tudp.srcport\ttcp.dstport\tdns.qry.name\tdns.qry.type\tdns.flags.rcode\n" +
+		"1\t1726565870.123456789\tip:udp:dns\t8.8.8.8\t64\t53\t\twww.googleapis.com\tA\t0\n"
+	require.Equal(t, expected, buf.String())
+
+	for _, name := range []string{"ip", "foo.src", "tcp.foo", "tcp.srcport.foo"} {
+		_, err := NewFieldsWriter(&buf, []string{name}, ',')
+		require.Error(t, err, name)
+	}
+}
@@ -1,12 +1,14 @@
-module github.com/shadowy-pycoder/mshark
+module github.com/shadowy-pycoder/mshark/v2
 
 go 1.23.0
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/mdlayher/packet v1.1.2
 	github.com/packetcap/go-pcap v0.0.0-20240528124601-8c87ecf5dbc5
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/net v0.28.0
+	golang.org/x/sys v0.24.0
 )
 
 require (
@@ -15,6 +17,5 @@ require (
 	github.com/mdlayher/socket v0.4.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
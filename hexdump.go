@@ -0,0 +1,88 @@
+package mshark
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const hexDumpWidth = 16 // the number of bytes in a line of hex dumps
+
+// byteRange is the range of packet data belonging to a decoded layer.
+type byteRange struct {
+	start, end int
+}
+
+// writeHexDump writes the data as lines of hex and ASCII prefixed by the offset, like tshark -x does:
+//
+//	0000  45 00 00 54 4c 2a 40 00  40 01 f0 7c 7f 00 00 01   E..TL*@.@..|....
+//
+// Bytes that can not be printed are shown as dots in ASCII. If color is true, bytes of layers with
+// the given ranges are highlighted with the colors used for the layers in packet descriptions.
+func writeHexDump(b *bytes.Buffer, data []byte, ranges []byteRange, color bool) {
+	var current string
+	setColor := func(offset int) {
+		if !color {
+			return
+		}
+		var c string
+		for i, r := range ranges {
+			if offset >= r.start && offset < r.end {
+				c = colorMap[i]
+				break
+			}
+		}
+		if c == current {
+			return
+		}
+		if c == "" {
+			b.WriteString("\033[0m")
+		} else {
+			b.WriteString(c)
+		}
+		current = c
+	}
+	resetColor := func() {
+		if current != "" {
+			b.WriteString("\033[0m")
+			current = ""
+		}
+	}
+	for line := 0; line < len(data); line += hexDumpWidth {
+		chunk := data[line:min(line+hexDumpWidth, len(data))]
+		fmt.Fprintf(b, "%04x  ", line)
+		for i, c := range chunk {
+			if i == hexDumpWidth/2 {
+				b.WriteByte(' ')
+			}
+			setColor(line + i)
+			fmt.Fprintf(b, "%02x ", c)
+		}
+		resetColor()
+		for i := len(chunk); i < hexDumpWidth; i++ {
+			if i == hexDumpWidth/2 {
+				b.WriteByte(' ')
+			}
+			b.WriteString("   ")
+		}
+		b.WriteString("  ")
+		for i, c := range chunk {
+			setColor(line + i)
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		resetColor()
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+}
+
+// layerRanges returns byte ranges of the decoded layers.
+func layerRanges(decoded []decodedLayer) []byteRange {
+	ranges := make([]byteRange, len(decoded))
+	for i, dl := range decoded {
+		ranges[i] = byteRange{dl.pos, dl.pos + dl.size}
+	}
+	return ranges
+}
@@ -0,0 +1,49 @@
+package mshark
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHexDump(t *testing.T) {
+	data := []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0fGET / HTTP")
+	var buf bytes.Buffer
+	writeHexDump(&buf, data, nil, false)
+	expected := "0000  00 01 02 03 04 05 06 07  08 09 0a 0b 0c 0d 0e 0f   ................\n" +
+		"0010  47 45 54 20 2f 20 48 54  54 50                     GET / HTTP\n\n"
+	require.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	writeHexDump(&buf, data[:4], []byteRange{{0, 2}, {2, 4}}, true)
+	expected = "0000  " + colorMap[0] + "00 01 " + colorMap[1] + "02 03 \033[0m" + strings.Repeat(" ", 39) +
+		colorMap[0] + ".." + colorMap[1] + "..\033[0m\n\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestWriterHexDump(t *testing.T) {
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, false)
+	w.SetHexDump(true)
+	// BSD loopback header is not a part of any layer
+	data := append([]byte{2, 0, 0, 0}, ipv4...)
+	if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeNull}, data); err != nil {
+		t.Fatal(err)
+	}
+	var dump []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > 6 && line[4:6] == "  " {
+			dump = append(dump, line)
+		}
+	}
+	require.Len(t, dump, (len(data)+15)/16)
+	require.True(t, strings.HasPrefix(dump[0], "0000  02 00 00 00 45 "), dump[0])
+}
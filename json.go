@@ -0,0 +1,90 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+)
+
+var _ PacketWriter = &JSONWriter{}
+
+// JSONWriter writes decoded packets as newline-delimited JSON (NDJSON), one object per packet:
+//
+//	{"frame":1,"timestamp":"2024-09-17T09:37:50.123456Z","interface_id":0,"length":74,"captured_length":74,
+//	"direction":"Outbound","layers":{"ETH":{"DstMAC":"...","SrcMAC":"...",...},"IPv4":{...},"TCP":{...}}}
+//
+// Layers are keyed by their names in layers.LayerMap in the order they were decoded,
+// and contain the exported fields of the corresponding layer types.
+type JSONWriter struct {
+	w       io.Writer
+	packets uint64
+	buf     bytes.Buffer
+}
+
+// jsonPacket is the JSON object written for every packet.
+type jsonPacket struct {
+	Frame          uint64          `json:"frame"`
+	Timestamp      time.Time       `json:"timestamp"`
+	InterfaceID    int             `json:"interface_id"`
+	Length         int             `json:"length"`
+	CapturedLength int             `json:"captured_length"`
+	Direction      string          `json:"direction,omitempty"`
+	Comment        string          `json:"comment,omitempty"`
+	Truncated      bool            `json:"truncated,omitempty"` // the last layer did not fit into the captured data
+	Layers         json.RawMessage `json:"layers"`
+}
+
+// NewJSONWriter creates a new JSONWriter.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+// WritePacket decodes the packet and writes it as a single line of JSON.
+//
+// Packets truncated by snaplen are decoded as far as possible and marked as such.
+// Layers are decoded with their own instances, so JSONWriter can be used
+// concurrently with other writers.
+func (jw *JSONWriter) WritePacket(ci capture.Info, data []byte) error {
+	p := jsonPacket{
+		Frame:          jw.packets + 1,
+		Timestamp:      ci.Timestamp,
+		InterfaceID:    ci.InterfaceID,
+		Length:         ci.OriginalLength(data),
+		CapturedLength: len(data),
+		Comment:        ci.Comment,
+	}
+	if ci.PacketType != capture.PacketTypeUnknown {
+		p.Direction = ci.PacketType.String()
+	}
+	jw.buf.Reset()
+	jw.buf.WriteByte('{')
+	decoded, truncated, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
+	for i, dl := range decoded {
+		b, err := json.Marshal(dl.layer)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s layer: %v", dl.name, err)
+		}
+		if i > 0 {
+			jw.buf.WriteByte(',')
+		}
+		fmt.Fprintf(&jw.buf, "%q:", dl.name)
+		jw.buf.Write(b)
+	}
+	jw.buf.WriteByte('}')
+	p.Truncated = truncated != ""
+	p.Layers = jw.buf.Bytes()
+	b, err := json.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	jw.packets++
+	_, err = jw.w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,80 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONWriter(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(eth, ipv4...)
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf)
+	ci := capture.Info{
+		Timestamp:  time.Date(2024, 9, 17, 9, 37, 50, 123456000, time.UTC),
+		LinkType:   capture.LinkTypeEthernet,
+		PacketType: capture.PacketTypeOutgoing,
+		Comment:    "incident started",
+	}
+	if err := w.WritePacket(ci, data); err != nil {
+		t.Fatal(err)
+	}
+	// IPv4 header does not fit into the captured data
+	truncated := append(eth, ipv4[:4]...)
+	if err := w.WritePacket(capture.Info{Length: 1514, LinkType: capture.LinkTypeEthernet}, truncated); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	// layers are written in the order they were decoded
+	require.Less(t, strings.Index(lines[0], `"ETH":`), strings.Index(lines[0], `"IPv4":`))
+
+	var p struct {
+		Frame          int
+		Timestamp      time.Time
+		Length         int
+		CapturedLength int `json:"captured_length"`
+		Direction      string
+		Comment        string
+		Truncated      bool
+		Layers         map[string]map[string]any
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &p); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 1, p.Frame)
+	require.Equal(t, ci.Timestamp, p.Timestamp)
+	require.Equal(t, len(data), p.Length)
+	require.Equal(t, len(data), p.CapturedLength)
+	require.Equal(t, "Outbound", p.Direction)
+	require.Equal(t, "incident started", p.Comment)
+	require.False(t, p.Truncated)
+	require.Equal(t, "7b:13:0b:87:ea:51", p.Layers["ETH"]["DstMAC"])
+	require.Equal(t, "IPv4", p.Layers["ETH"]["EtherTypeDesc"])
+	require.Contains(t, p.Layers, "IPv4")
+	require.Contains(t, p.Layers["IPv4"], "SrcIP")
+
+	p.Layers = nil
+	if err := json.Unmarshal([]byte(lines[1]), &p); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 2, p.Frame)
+	require.Equal(t, 1514, p.Length)
+	require.True(t, p.Truncated)
+	require.Len(t, p.Layers, 1)
+	require.Contains(t, p.Layers, "ETH")
+}
@@ -2,6 +2,7 @@ package layers
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/netip"
@@ -32,6 +33,16 @@ type ARPPacket struct {
 	TargetIP  netip.Addr // Internetwork address of the intended receiver.
 }
 
+// MarshalJSON encodes the packet with MAC addresses in their usual notation.
+func (ap *ARPPacket) MarshalJSON() ([]byte, error) {
+	type packet ARPPacket
+	return json.Marshal(&struct {
+		SenderMAC string
+		TargetMAC string
+		*packet
+	}{ap.SenderMAC.String(), ap.TargetMAC.String(), (*packet)(ap)})
+}
+
 func (ap *ARPPacket) String() string {
 	return fmt.Sprintf(`%s
 - Hardware Type: %d
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
 )
 
@@ -270,6 +271,51 @@ func (d *DNSMessage) Parse(data []byte) error {
 
 func (d *DNSMessage) NextLayer() (layer string, payload []byte) { return }
 
+// DNSHost is an IPv4 or IPv6 address with the names resolved to it.
+type DNSHost struct {
+	Addr  netip.Addr
+	Names []string
+}
+
+// Hosts returns addresses from A and AAAA records in the answers section of a reply
+// along with their names. Aliases pointing to the names with CNAME records are included as well.
+//
+// Returned names do not refer to the parsed data.
+func (d *DNSMessage) Hosts() []DNSHost {
+	if d.Flags == nil || d.Flags.QR != 1 {
+		return nil
+	}
+	aliases := make(map[string][]string) // canonical name -> aliases
+	for _, rr := range d.AnswerRRs {
+		if rdata, ok := rr.RData.(*RDataCNAME); ok {
+			aliases[rdata.CName] = append(aliases[rdata.CName], rr.Name)
+		}
+	}
+	var hosts []DNSHost
+	for _, rr := range d.AnswerRRs {
+		var addr netip.Addr
+		switch rdata := rr.RData.(type) {
+		case *RDataA:
+			addr = rdata.Address
+		case *RDataAAAA:
+			addr = rdata.Address
+		default:
+			continue
+		}
+		names := []string{strings.Clone(rr.Name)}
+		// following CNAME chain back to the queried name
+		for i := 0; i < len(names); i++ {
+			for _, alias := range aliases[names[i]] {
+				if !slices.Contains(names, alias) {
+					names = append(names, strings.Clone(alias))
+				}
+			}
+		}
+		hosts = append(hosts, DNSHost{Addr: addr, Names: names})
+	}
+	return hosts
+}
+
 func (d *DNSMessage) printRecords() string {
 	var sb strings.Builder
 	if d.QDCount > 0 {
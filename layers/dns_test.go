@@ -398,3 +398,37 @@ func TestParseDNS(t *testing.T) {
 	}
 	require.Equal(t, expected, dns)
 }
+
+func TestDNSHosts(t *testing.T) {
+	dns := &DNSMessage{}
+	packet, close := testPacket(t, "dns")
+	defer close()
+	if err := dns.Parse(packet); err != nil {
+		t.Fatal(err)
+	}
+	hosts := dns.Hosts()
+	require.Len(t, hosts, 7)
+	require.Equal(t, DNSHost{
+		Addr:  netip.AddrFrom4([4]byte{0x8e, 0xfa, 0x4a, 0x4a}),
+		Names: []string{"www.googleapis.com"},
+	}, hosts[0])
+
+	cname := func(name, target string) *ResourceRecord {
+		return &ResourceRecord{Name: name, RData: &RDataCNAME{CName: target}}
+	}
+	dns = &DNSMessage{
+		Flags: &DNSFlags{QR: 1},
+		AnswerRRs: []*ResourceRecord{
+			cname("www.example.com", "cdn.example.net"),
+			cname("cdn.example.net", "edge.example.org"),
+			{Name: "edge.example.org", RData: &RDataAAAA{Address: netip.MustParseAddr("2001:db8::1")}},
+		},
+	}
+	require.Equal(t, []DNSHost{{
+		Addr:  netip.MustParseAddr("2001:db8::1"),
+		Names: []string{"edge.example.org", "cdn.example.net", "www.example.com"},
+	}}, dns.Hosts())
+	// queries have no answers to learn from
+	dns.Flags.QR = 0
+	require.Empty(t, dns.Hosts())
+}
@@ -3,6 +3,7 @@ package layers
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
 )
@@ -18,6 +19,16 @@ type EthernetFrame struct {
 	payload       []byte
 }
 
+// MarshalJSON encodes the frame with MAC addresses in their usual notation.
+func (ef *EthernetFrame) MarshalJSON() ([]byte, error) {
+	type frame EthernetFrame
+	return json.Marshal(&struct {
+		DstMAC string
+		SrcMAC string
+		*frame
+	}{ef.DstMAC.String(), ef.SrcMAC.String(), (*frame)(ef)})
+}
+
 func (ef *EthernetFrame) String() string {
 	return fmt.Sprintf(`%s
 - DstMAC: %s
@@ -53,16 +64,19 @@ func (ef *EthernetFrame) Parse(data []byte) error {
 
 // NextLayer returns the name and payload of the next layer protocol based on the EtherType field of the EthernetFrame.
 func (ef *EthernetFrame) NextLayer() (string, []byte) {
-	var layer string
-	switch ef.EtherType {
+	return etherTypeLayer(ef.EtherType), ef.payload
+}
+
+// etherTypeLayer returns the name of the layer protocol identified by the EtherType.
+func etherTypeLayer(etherType uint16) string {
+	switch etherType {
 	case 0x0800:
-		layer = "IPv4"
+		return "IPv4"
 	case 0x0806:
-		layer = "ARP"
+		return "ARP"
 	case 0x86dd:
-		layer = "IPv6"
+		return "IPv6"
 	default:
-		layer = ""
+		return ""
 	}
-	return layer, ef.payload
 }
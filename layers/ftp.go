@@ -2,6 +2,7 @@ package layers
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 )
 
@@ -10,6 +11,14 @@ type FTPMessage struct {
 	data    []byte
 }
 
+// MarshalJSON encodes the summary of the message and its lines.
+func (f *FTPMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Summary string
+		Lines   []string `json:",omitempty"`
+	}{string(f.summary), splitLines(f.data)})
+}
+
 func (f *FTPMessage) String() string {
 	return fmt.Sprintf(`%s
 %s
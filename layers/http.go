@@ -2,6 +2,7 @@ package layers
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 )
 
@@ -12,6 +13,14 @@ type HTTPMessage struct {
 	data    []byte
 }
 
+// MarshalJSON encodes the summary of the message and its start line and headers one per line.
+func (h *HTTPMessage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Summary string
+		Lines   []string `json:",omitempty"`
+	}{string(h.summary), splitLines(h.data)})
+}
+
 func (h *HTTPMessage) String() string {
 	return fmt.Sprintf(`%s
 %s
@@ -1,6 +1,7 @@
 package layers
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"testing"
@@ -55,3 +56,27 @@ func TestParseHTTP(t *testing.T) {
 	}
 	require.Equal(t, expected, http)
 }
+
+func TestMarshalJSONHTTP(t *testing.T) {
+	http := &HTTPMessage{}
+	packet, close := testPacket(t, "http")
+	defer close()
+	if err := http.Parse(packet); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(http)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Summary string
+		Lines   []string
+	}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "POST /api HTTP/1.1 host: 149.154.167.222:80", msg.Summary)
+	require.Len(t, msg.Lines, 8)
+	require.Equal(t, "POST /api HTTP/1.1", msg.Lines[0])
+	require.Equal(t, "user-agent: Mozilla/5.0", msg.Lines[7])
+}
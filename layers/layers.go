@@ -1,7 +1,9 @@
 package layers
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
 	"unsafe"
 )
 
@@ -9,6 +11,8 @@ const maxLenSummary = 100
 
 var LayerMap = map[string]Layer{
 	"ETH":    &EthernetFrame{},
+	"SLL":    &LinuxSLL{},
+	"SLL2":   &LinuxSLL2{},
 	"IPv4":   &IPv4Packet{},
 	"IPv6":   &IPv6Packet{},
 	"ARP":    &ARPPacket{},
@@ -24,6 +28,17 @@ var LayerMap = map[string]Layer{
 	"TLS":    &TLSMessage{},
 }
 
+// NewLayer returns a new layer with the given name from LayerMap or nil if there is no such layer.
+//
+// Layers in LayerMap are shared, while new layers can be used concurrently with them.
+func NewLayer(name string) Layer {
+	l, ok := LayerMap[name]
+	if !ok {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(l).Elem()).Interface().(Layer)
+}
+
 var (
 	bspace   = []byte(" ")
 	dash     = []byte("- ")
@@ -58,3 +73,15 @@ func joinBytes(bs ...[]byte) []byte {
 	}
 	return b
 }
+
+// splitLines splits data formatted as "- line" lines into separate lines without dashes.
+func splitLines(data []byte) []string {
+	if !bytes.HasPrefix(data, dash) {
+		return nil
+	}
+	var lines []string
+	for _, line := range bytes.Split(data[len(dash):], lfd) {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
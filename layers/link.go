@@ -0,0 +1,49 @@
+package layers
+
+import (
+	"fmt"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+)
+
+// linkLayers maps link types to the layers packet decoding starts with.
+var linkLayers = map[int]string{
+	capture.LinkTypeEthernet:  "ETH",
+	capture.LinkTypeLinuxSLL:  "SLL",
+	capture.LinkTypeLinuxSLL2: "SLL2",
+	capture.LinkTypeIPv4:      "IPv4",
+	capture.LinkTypeIPv6:      "IPv6",
+}
+
+// FirstLayer returns the name and data of the layer packet decoding starts with.
+//
+// Packets without link-layer header (raw IP and BSD loopback) start with IPv4 or IPv6 header
+// told apart by the version field.
+func FirstLayer(linkType int, data []byte) (string, []byte, error) {
+	switch linkType {
+	case capture.LinkTypeNull:
+		// 4-byte address family in the byte order of the host that captured the packet
+		if len(data) < 4 {
+			return "", nil, fmt.Errorf("minimum header size for loopback is 4 bytes, got %d bytes", len(data))
+		}
+		data = data[4:]
+		fallthrough
+	case capture.LinkTypeRaw:
+		if len(data) == 0 {
+			return "", nil, fmt.Errorf("empty IP packet")
+		}
+		switch version := data[0] >> 4; version {
+		case 4:
+			return "IPv4", data, nil
+		case 6:
+			return "IPv6", data, nil
+		default:
+			return "", nil, fmt.Errorf("unknown IP version %d", version)
+		}
+	}
+	name, ok := linkLayers[linkType]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+	return name, data, nil
+}
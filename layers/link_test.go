@@ -0,0 +1,45 @@
+package layers
+
+import (
+	"testing"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstLayer(t *testing.T) {
+	ipv6, close := testPacket(t, "ipv6")
+	defer close()
+	for _, tt := range []struct {
+		linkType int
+		data     []byte
+		expected string
+	}{
+		{capture.LinkTypeEthernet, ipv6, "ETH"},
+		{capture.LinkTypeLinuxSLL2, ipv6, "SLL2"},
+		{capture.LinkTypeIPv6, ipv6, "IPv6"},
+		{capture.LinkTypeRaw, ipv6, "IPv6"},
+		{capture.LinkTypeRaw, []byte{0x45, 0x00}, "IPv4"},
+		{capture.LinkTypeNull, []byte{0x1e, 0, 0, 0, 0x60, 0x00}, "IPv6"},
+	} {
+		name, _, err := FirstLayer(tt.linkType, tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, tt.expected, name)
+	}
+	_, data, err := FirstLayer(capture.LinkTypeNull, []byte{2, 0, 0, 0, 0x45})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x45}, data)
+	_, _, err = FirstLayer(capture.LinkTypeRaw, []byte{0x50})
+	require.Error(t, err)
+	_, _, err = FirstLayer(12345, ipv6)
+	require.Error(t, err)
+}
+
+func TestNewLayer(t *testing.T) {
+	l := NewLayer("DNS")
+	require.IsType(t, &DNSMessage{}, l)
+	require.NotSame(t, LayerMap["DNS"], l)
+	require.Nil(t, NewLayer("unknown"))
+}
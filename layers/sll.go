@@ -0,0 +1,174 @@
+package layers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+const (
+	headerSizeSLL  = 16
+	headerSizeSLL2 = 20
+)
+
+// pktTypeDesc returns the description of Linux packet type (PACKET_* constants in linux/if_packet.h).
+func pktTypeDesc(pktType uint8) string {
+	switch pktType {
+	case 0:
+		return "Unicast to us"
+	case 1:
+		return "Broadcast"
+	case 2:
+		return "Multicast"
+	case 3:
+		return "Unicast to another host"
+	case 4:
+		return "Sent by us"
+	default:
+		return "Unknown"
+	}
+}
+
+// Linux cooked capture header (LINKTYPE_LINUX_SLL) is used instead of link-layer header
+// when capturing on "any" interface.
+// https://www.tcpdump.org/linktypes/LINKTYPE_LINUX_SLL.html
+type LinuxSLL struct {
+	PacketType     uint8            // Whether the packet was sent to us, by us, broadcast etc.
+	PacketTypeDesc string           // Packet type description.
+	ARPHRDType     uint16           // Linux ARPHRD_ value for the link-layer device type.
+	AddrLen        uint16           // The length of the link-layer address of the sender.
+	Addr           net.HardwareAddr // The link-layer address of the sender.
+	Protocol       uint16           // The protocol of the upper layer, usually an EtherType.
+	ProtocolDesc   string           // Protocol description.
+	payload        []byte
+}
+
+// MarshalJSON encodes the header with the link-layer address in its usual notation.
+func (s *LinuxSLL) MarshalJSON() ([]byte, error) {
+	type header LinuxSLL
+	return json.Marshal(&struct {
+		Addr string
+		*header
+	}{s.Addr.String(), (*header)(s)})
+}
+
+func (s *LinuxSLL) String() string {
+	return fmt.Sprintf(`%s
+- Packet Type: %s (%d)
+- ARPHRD Type: %d
+- Address Length: %d
+- Address: %s
+- Protocol: %s (%#04x)
+- Payload: %d bytes
+%s`,
+		s.Summary(),
+		s.PacketTypeDesc,
+		s.PacketType,
+		s.ARPHRDType,
+		s.AddrLen,
+		s.Addr,
+		s.ProtocolDesc,
+		s.Protocol,
+		len(s.payload),
+		hex.Dump(s.payload))
+}
+
+func (s *LinuxSLL) Summary() string {
+	return fmt.Sprintf("Linux Cooked Capture: %s Src Addr: %s", s.PacketTypeDesc, s.Addr)
+}
+
+// Parse parses the given byte data into a Linux cooked capture header.
+func (s *LinuxSLL) Parse(data []byte) error {
+	if len(data) < headerSizeSLL {
+		return fmt.Errorf("minimum header size for Linux SLL is %d bytes, got %d bytes", headerSizeSLL, len(data))
+	}
+	s.PacketType = uint8(binary.BigEndian.Uint16(data[0:2]))
+	s.PacketTypeDesc = pktTypeDesc(s.PacketType)
+	s.ARPHRDType = binary.BigEndian.Uint16(data[2:4])
+	s.AddrLen = binary.BigEndian.Uint16(data[4:6])
+	s.Addr = net.HardwareAddr(data[6 : 6+min(s.AddrLen, 8)])
+	s.Protocol = binary.BigEndian.Uint16(data[14:16])
+	s.payload = data[headerSizeSLL:]
+	s.ProtocolDesc, _ = s.NextLayer()
+	return nil
+}
+
+// NextLayer returns the name and payload of the next layer protocol based on the Protocol field.
+func (s *LinuxSLL) NextLayer() (string, []byte) {
+	return etherTypeLayer(s.Protocol), s.payload
+}
+
+// Linux cooked capture header v2 (LINKTYPE_LINUX_SLL2) additionally contains the index
+// of the interface the packet was captured on.
+// https://www.tcpdump.org/linktypes/LINKTYPE_LINUX_SLL2.html
+type LinuxSLL2 struct {
+	Protocol       uint16           // The protocol of the upper layer, usually an EtherType.
+	ProtocolDesc   string           // Protocol description.
+	InterfaceIndex uint32           // The index of the interface the packet was captured on.
+	ARPHRDType     uint16           // Linux ARPHRD_ value for the link-layer device type.
+	PacketType     uint8            // Whether the packet was sent to us, by us, broadcast etc.
+	PacketTypeDesc string           // Packet type description.
+	AddrLen        uint8            // The length of the link-layer address of the sender.
+	Addr           net.HardwareAddr // The link-layer address of the sender.
+	payload        []byte
+}
+
+// MarshalJSON encodes the header with the link-layer address in its usual notation.
+func (s *LinuxSLL2) MarshalJSON() ([]byte, error) {
+	type header LinuxSLL2
+	return json.Marshal(&struct {
+		Addr string
+		*header
+	}{s.Addr.String(), (*header)(s)})
+}
+
+func (s *LinuxSLL2) String() string {
+	return fmt.Sprintf(`%s
+- Protocol: %s (%#04x)
+- Interface Index: %d
+- ARPHRD Type: %d
+- Packet Type: %s (%d)
+- Address Length: %d
+- Address: %s
+- Payload: %d bytes
+%s`,
+		s.Summary(),
+		s.ProtocolDesc,
+		s.Protocol,
+		s.InterfaceIndex,
+		s.ARPHRDType,
+		s.PacketTypeDesc,
+		s.PacketType,
+		s.AddrLen,
+		s.Addr,
+		len(s.payload),
+		hex.Dump(s.payload))
+}
+
+func (s *LinuxSLL2) Summary() string {
+	return fmt.Sprintf("Linux Cooked Capture v2: Interface Index: %d %s Src Addr: %s", s.InterfaceIndex, s.PacketTypeDesc, s.Addr)
+}
+
+// Parse parses the given byte data into a Linux cooked capture v2 header.
+func (s *LinuxSLL2) Parse(data []byte) error {
+	if len(data) < headerSizeSLL2 {
+		return fmt.Errorf("minimum header size for Linux SLL2 is %d bytes, got %d bytes", headerSizeSLL2, len(data))
+	}
+	s.Protocol = binary.BigEndian.Uint16(data[0:2])
+	s.InterfaceIndex = binary.BigEndian.Uint32(data[4:8])
+	s.ARPHRDType = binary.BigEndian.Uint16(data[8:10])
+	s.PacketType = data[10]
+	s.PacketTypeDesc = pktTypeDesc(s.PacketType)
+	s.AddrLen = data[11]
+	s.Addr = net.HardwareAddr(data[12 : 12+min(s.AddrLen, 8)])
+	s.payload = data[headerSizeSLL2:]
+	s.ProtocolDesc, _ = s.NextLayer()
+	return nil
+}
+
+// NextLayer returns the name and payload of the next layer protocol based on the Protocol field.
+func (s *LinuxSLL2) NextLayer() (string, []byte) {
+	return etherTypeLayer(s.Protocol), s.payload
+}
@@ -0,0 +1,49 @@
+package layers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSLL(t *testing.T) {
+	expected := &LinuxSLL{
+		PacketType:     0,
+		PacketTypeDesc: "Unicast to us",
+		ARPHRDType:     1,
+		AddrLen:        6,
+		Addr:           net.HardwareAddr{0x02, 0x42, 0xc0, 0x00, 0x02, 0x02},
+		Protocol:       0x86dd,
+		ProtocolDesc:   "IPv6",
+		payload:        []byte{},
+	}
+	sll := &LinuxSLL{}
+	packet, close := testPacket(t, "sll")
+	defer close()
+	if err := sll.Parse(packet); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, expected, sll)
+}
+
+func TestParseSLL2(t *testing.T) {
+	expected := &LinuxSLL2{
+		Protocol:       0x0800,
+		ProtocolDesc:   "IPv4",
+		InterfaceIndex: 2,
+		ARPHRDType:     1,
+		PacketType:     4,
+		PacketTypeDesc: "Sent by us",
+		AddrLen:        6,
+		Addr:           net.HardwareAddr{0x02, 0x42, 0xc0, 0x00, 0x02, 0x02},
+		payload:        []byte{},
+	}
+	sll := &LinuxSLL2{}
+	packet, close := testPacket(t, "sll2")
+	defer close()
+	if err := sll.Parse(packet); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, expected, sll)
+}
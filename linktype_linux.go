@@ -0,0 +1,38 @@
+package mshark
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"golang.org/x/sys/unix"
+)
+
+// interfaceLinkType returns the link type of the interface based on its
+// ARPHRD_ device type read from /sys/class/net/<name>/type.
+//
+// Loopback devices have fake Ethernet headers, while tunnels (tun, WireGuard,
+// PPP, IP-in-IP, SIT) have no link-layer header at all and packets start with IP header.
+func interfaceLinkType(in *net.Interface) (int, error) {
+	b, err := os.ReadFile(filepath.Join("/sys/class/net", in.Name, "type"))
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine link type of %s: %v", in.Name, err)
+	}
+	hatype, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine link type of %s: %v", in.Name, err)
+	}
+	switch hatype {
+	case unix.ARPHRD_ETHER, unix.ARPHRD_LOOPBACK:
+		return capture.LinkTypeEthernet, nil
+	case unix.ARPHRD_NONE, unix.ARPHRD_RAWIP, unix.ARPHRD_PPP,
+		unix.ARPHRD_TUNNEL, unix.ARPHRD_TUNNEL6, unix.ARPHRD_SIT:
+		return capture.LinkTypeRaw, nil
+	default:
+		return 0, fmt.Errorf("interface %s has unsupported device type %d", in.Name, hatype)
+	}
+}
@@ -0,0 +1,113 @@
+package mpcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+)
+
+const maxPacketLen uint32 = 262144 // MAXIMUM_SNAPLEN in libpcap
+
+type Reader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	nanosecs  bool
+	snaplen   uint32
+	linkType  uint32
+	buf       [16]byte
+}
+
+// NewReader creates a new PCAP Reader that reads from the given io.Reader.
+//
+// The global header is read immediately. Both byte orders are supported,
+// as well as microsecond and nanosecond timestamp resolution.
+// Files compressed with gzip or zstd are decompressed transparently.
+//
+// See https://wiki.wireshark.org/Development/LibpcapFileFormat for more
+// information about the pcap file format.
+func NewReader(r io.Reader) (*Reader, error) {
+	r, err := compress.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading global header: %v", err)
+	}
+	pr := &Reader{r: r}
+	if err := pr.readHeader(); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+func (pr *Reader) readHeader() error {
+	var buf [24]byte
+	if _, err := io.ReadFull(pr.r, buf[:]); err != nil {
+		return fmt.Errorf("error reading global header: %v", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(buf[0:4]) == magicNumber:
+		pr.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(buf[0:4]) == magicNumber:
+		pr.byteOrder = binary.BigEndian
+	case binary.LittleEndian.Uint32(buf[0:4]) == magicNumberNano:
+		pr.byteOrder = binary.LittleEndian
+		pr.nanosecs = true
+	case binary.BigEndian.Uint32(buf[0:4]) == magicNumberNano:
+		pr.byteOrder = binary.BigEndian
+		pr.nanosecs = true
+	default:
+		return fmt.Errorf("unknown magic number %#08x", buf[0:4])
+	}
+	if major := pr.byteOrder.Uint16(buf[4:6]); major != versionMajor {
+		return fmt.Errorf("unsupported pcap version %d.%d", major, pr.byteOrder.Uint16(buf[6:8]))
+	}
+	pr.snaplen = pr.byteOrder.Uint32(buf[16:20])
+	pr.linkType = pr.byteOrder.Uint32(buf[20:24])
+	return nil
+}
+
+// LinkType returns the link-layer header type of the packets in the file.
+func (pr *Reader) LinkType() uint32 {
+	return pr.linkType
+}
+
+// Snaplen returns the maximum length of each packet snapshot stored in the file.
+func (pr *Reader) Snaplen() int {
+	return int(pr.snaplen)
+}
+
+// ReadPacket reads the next packet from the pcap file.
+//
+// It returns the metadata of the packet and its captured data.
+// When there are no more packets, io.EOF is returned.
+func (pr *Reader) ReadPacket() (capture.Info, []byte, error) {
+	if _, err := io.ReadFull(pr.r, pr.buf[:]); err != nil {
+		if err == io.EOF {
+			return capture.Info{}, nil, err
+		}
+		return capture.Info{}, nil, fmt.Errorf("error reading packet header: %v", err)
+	}
+	secs := pr.byteOrder.Uint32(pr.buf[0:4])
+	frac := pr.byteOrder.Uint32(pr.buf[4:8])
+	capLen := pr.byteOrder.Uint32(pr.buf[8:12])
+	origLen := pr.byteOrder.Uint32(pr.buf[12:16])
+	if capLen > maxPacketLen {
+		return capture.Info{}, nil, fmt.Errorf("invalid packet length %d", capLen)
+	}
+	nsecs := int64(frac)
+	if !pr.nanosecs {
+		nsecs *= 1e3
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return capture.Info{}, nil, fmt.Errorf("error reading packet data: %v", err)
+	}
+	ci := capture.Info{
+		Timestamp: time.Unix(int64(secs), nsecs).UTC(),
+		Length:    int(origLen),
+		LinkType:  int(pr.linkType & 0xffff), // upper bits may contain FCS length
+	}
+	return ci, data, nil
+}
@@ -0,0 +1,174 @@
+package mpcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPacket(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteHeader(1600); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2024, 9, 17, 9, 37, 50, 0, time.UTC)
+	packets := [][]byte{{0xde, 0xad, 0xbe, 0xef}, {0x01, 0x02, 0x03}}
+	for _, p := range packets {
+		if err := w.WritePacket(capture.Info{Timestamp: timestamp}, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 1600, r.Snaplen())
+	require.Equal(t, uint32(1), r.LinkType())
+	for _, p := range packets {
+		ci, data, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, timestamp, ci.Timestamp)
+		require.Equal(t, p, data)
+	}
+	_, _, err = r.ReadPacket()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadPacketPrecision(t *testing.T) {
+	timestamp := time.Date(2024, 9, 17, 9, 37, 50, 123456789, time.UTC)
+	for _, tt := range []struct {
+		precision capture.Precision
+		expected  time.Time
+	}{
+		{capture.Microsecond, timestamp.Truncate(time.Microsecond)},
+		{capture.Nanosecond, timestamp},
+	} {
+		var buf bytes.Buffer
+		w := NewWriterPrecision(&buf, tt.precision)
+		if err := w.WriteHeader(1600); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WritePacket(capture.Info{Timestamp: timestamp}, []byte{0xca, 0xfe}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ci, _, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, tt.expected, ci.Timestamp)
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteHeader(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{Length: 1500}, []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, data, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 1500, ci.Length)
+	require.True(t, ci.Truncated(data))
+}
+
+func TestReadPacketLinkType(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteHeaderLinkType(1600, capture.LinkTypeLinuxSLL2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{}, []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, uint32(capture.LinkTypeLinuxSLL2), r.LinkType())
+	ci, _, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, capture.LinkTypeLinuxSLL2, ci.LinkType)
+}
+
+func TestReadPacketNanoBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, magicNumberNano)
+	binary.Write(&buf, binary.BigEndian, versionMajor)
+	binary.Write(&buf, binary.BigEndian, versionMinor)
+	binary.Write(&buf, binary.BigEndian, thisZone)
+	binary.Write(&buf, binary.BigEndian, sigFigs)
+	binary.Write(&buf, binary.BigEndian, uint32(65535))
+	binary.Write(&buf, binary.BigEndian, network)
+	binary.Write(&buf, binary.BigEndian, uint32(1726565870))
+	binary.Write(&buf, binary.BigEndian, uint32(123456789))
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(60))
+	buf.Write([]byte{0xca, 0xfe})
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 65535, r.Snaplen())
+	ci, data, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, time.Unix(1726565870, 123456789).UTC(), ci.Timestamp)
+	require.Equal(t, []byte{0xca, 0xfe}, data)
+}
+
+func TestReadPacketCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	zw, err := compress.NewWriter(&buf, compress.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(zw)
+	if err := w.WriteHeader(1600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{}, []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, []byte{0xca, 0xfe}, data)
+}
+
+func TestReadHeaderUnknownMagic(t *testing.T) {
+	_, err := NewReader(bytes.NewReader(make([]byte, 24)))
+	require.Error(t, err)
+}
@@ -5,31 +5,43 @@ import (
 	"io"
 	"time"
 
-	"github.com/shadowy-pycoder/mshark/native"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/native"
 )
 
 // https://wiki.wireshark.org/Development/LibpcapFileFormat/
 const (
-	magicNumber  uint32 = 0xa1b2c3d4
-	versionMajor uint16 = 2
-	versionMinor uint16 = 4
-	thisZone     int32  = 0
-	sigFigs      uint32 = 0
-	network      uint32 = 1
+	magicNumber     uint32 = 0xa1b2c3d4
+	magicNumberNano uint32 = 0xa1b23c4d
+	versionMajor    uint16 = 2
+	versionMinor    uint16 = 4
+	thisZone        int32  = 0
+	sigFigs         uint32 = 0
+	network         uint32 = 1
 )
 
 var nativeEndian = native.Endian
 
 type Writer struct {
-	w   io.Writer
-	buf [16]byte
+	w         io.Writer
+	precision capture.Precision
+	buf       [16]byte
 }
 
 // NewWriter creates a new PCAP Writer that writes to the given io.Writer.
+//
+// Timestamps are written with microsecond resolution.
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{w: w}
 }
 
+// NewWriterPrecision is like NewWriter but writes timestamps with the given resolution.
+//
+// Files with nanosecond resolution are marked with a different magic number.
+func NewWriterPrecision(w io.Writer, p capture.Precision) *Writer {
+	return &Writer{w: w, precision: p}
+}
+
 // WriteHeader writes a global header block to the pcap file.
 //
 // The global header block contains metadata about the capture, such as the
@@ -38,25 +50,38 @@ func NewWriter(w io.Writer) *Writer {
 // See https://wiki.wireshark.org/Development/LibpcapFileFormat for more
 // information about the pcap file format.
 func (pw *Writer) WriteHeader(snaplen int) error {
+	return pw.WriteHeaderLinkType(snaplen, network)
+}
+
+// WriteHeaderLinkType is like WriteHeader but with the given link-layer header type
+// instead of Ethernet. All packets in the file must have the same link type.
+func (pw *Writer) WriteHeaderLinkType(snaplen int, linkType uint32) error {
 	var buf [24]byte
-	nativeEndian.PutUint32(buf[0:4], magicNumber)
+	magic := magicNumber
+	if pw.precision == capture.Nanosecond {
+		magic = magicNumberNano
+	}
+	nativeEndian.PutUint32(buf[0:4], magic)
 	nativeEndian.PutUint16(buf[4:6], versionMajor)
 	nativeEndian.PutUint16(buf[6:8], versionMinor)
 	nativeEndian.PutUint32(buf[8:12], uint32(thisZone))
 	nativeEndian.PutUint32(buf[12:16], sigFigs)
 	nativeEndian.PutUint32(buf[16:20], uint32(snaplen))
-	nativeEndian.PutUint32(buf[20:24], network)
+	nativeEndian.PutUint32(buf[20:24], linkType)
 	_, err := pw.w.Write(buf[:])
 	return err
 }
 
-func (pw *Writer) writePacketHeader(timestamp time.Time, packetLen int) error {
+func (pw *Writer) writePacketHeader(timestamp time.Time, capLen, origLen int) error {
 	secs := timestamp.Unix()
-	msecs := timestamp.Nanosecond() / 1e6
+	frac := timestamp.Nanosecond()
+	if pw.precision != capture.Nanosecond {
+		frac /= 1e3
+	}
 	nativeEndian.PutUint32(pw.buf[0:4], uint32(secs))
-	nativeEndian.PutUint32(pw.buf[4:8], uint32(msecs))
-	nativeEndian.PutUint32(pw.buf[8:12], uint32(packetLen))
-	nativeEndian.PutUint32(pw.buf[12:16], uint32(packetLen))
+	nativeEndian.PutUint32(pw.buf[4:8], uint32(frac))
+	nativeEndian.PutUint32(pw.buf[8:12], uint32(capLen))
+	nativeEndian.PutUint32(pw.buf[12:16], uint32(origLen))
 	_, err := pw.w.Write(pw.buf[:])
 	return err
 }
@@ -64,14 +89,15 @@ func (pw *Writer) writePacketHeader(timestamp time.Time, packetLen int) error {
 // WritePacket writes a packet to the pcap file.
 //
 // The packet is written as a packet header plus the packet data.
-// The timestamp is written as the number of seconds since the epoch,
-// and the packet data is written as a sequence of bytes of the length
+// The timestamp is written as the number of seconds since the epoch
+// plus microseconds or nanoseconds depending on the precision, and
+// the packet data is written as a sequence of bytes of the length
 // specified in the packet header.
 //
 // See https://wiki.wireshark.org/Development/LibpcapFileFormat for more
 // information about the pcap file format.
-func (pw *Writer) WritePacket(timestamp time.Time, data []byte) error {
-	if err := pw.writePacketHeader(timestamp, len(data)); err != nil {
+func (pw *Writer) WritePacket(ci capture.Info, data []byte) error {
+	if err := pw.writePacketHeader(ci.Timestamp, len(data), ci.OriginalLength(data)); err != nil {
 		return fmt.Errorf("error writing packet header: %v", err)
 	}
 	_, err := pw.w.Write(data)
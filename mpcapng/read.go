@@ -0,0 +1,584 @@
+package mpcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+)
+
+const (
+	spbBlockType      uint32 = 0x00000003
+	nrbBlockType      uint32 = 0x00000004
+	dsbBlockType      uint32 = 0x0000000a
+	nrbRecordEnd      uint16 = 0x0000
+	nrbRecordIPv4     uint16 = 0x0001
+	nrbRecordIPv6     uint16 = 0x0002
+	isbBlockType      uint32 = 0x00000005
+	optEndOfOpt       uint16 = 0x0000
+	optComment        uint16 = 0x0001
+	ifTsOffsetCode    uint16 = 0x000e
+	isbStartTimeCode  uint16 = 0x0002
+	isbEndTimeCode    uint16 = 0x0003
+	isbIfRecvCode     uint16 = 0x0004
+	isbIfDropCode     uint16 = 0x0005
+	defaultTsResol    uint8  = 6
+	maxBlockLen       uint32 = 16 * 1024 * 1024
+	blockHeaderLen    uint32 = 4 + 4 // block type + block total length
+	blockTrailerLen   uint32 = 4     // block total length
+	byteOrderMagicLen uint32 = 4
+)
+
+// Option is a generic pcapng option as stored in a block.
+type Option struct {
+	Code  uint16
+	Value []byte
+}
+
+// Section describes a section started by a Section Header Block (SHB).
+type Section struct {
+	VersionMajor uint16
+	VersionMinor uint16
+	Hardware     string // shb_hardware option.
+	OS           string // shb_os option.
+	UserAppl     string // shb_userappl option.
+	Options      []Option
+	Interfaces   []*Interface            // Interfaces described so far in this section, indexed by interface ID.
+	Hosts        map[netip.Addr][]string // Host names from Name Resolution Blocks (NRB) read so far.
+	Secrets      []*DecryptionSecrets    // Decryption Secrets Blocks (DSB) read so far.
+}
+
+// DecryptionSecrets describes the contents of a Decryption Secrets Block (DSB).
+type DecryptionSecrets struct {
+	Type    uint32 // Secrets type, e.g. SecretsTypeTLSKeyLog.
+	Data    []byte
+	Options []Option
+}
+
+// Interface describes an interface defined by an Interface Description Block (IDB).
+type Interface struct {
+	LinkType    uint16
+	Snaplen     uint32
+	Name        string          // if_name option.
+	Description string          // if_description option.
+	Filter      string          // if_filter option (without the filter type byte).
+	OS          string          // if_os option.
+	TsResol     uint8           // if_tsresol option, defaults to 6 (microseconds).
+	TsOffset    int64           // if_tsoffset option in seconds.
+	Options     []Option        // All options of the block, including parsed ones.
+	Stats       *InterfaceStats // The latest Interface Statistics Block (ISB) for this interface.
+}
+
+// InterfaceStats describes the contents of an Interface Statistics Block (ISB).
+type InterfaceStats struct {
+	Timestamp time.Time
+	StartTime time.Time // isb_starttime option.
+	EndTime   time.Time // isb_endtime option.
+	Received  uint64    // isb_ifrecv option.
+	Dropped   uint64    // isb_ifdrop option.
+	Options   []Option
+}
+
+// Packet is a packet read from an Enhanced Packet Block (EPB) or a Simple Packet Block (SPB).
+type Packet struct {
+	InterfaceID   uint32
+	Timestamp     time.Time // Zero for packets from Simple Packet Blocks.
+	CaptureLength int
+	Length        int    // Original length of the packet on the wire.
+	Flags         uint32 // epb_flags option.
+	Data          []byte
+	Options       []Option
+}
+
+type Reader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	section   *Section
+	buf       [12]byte
+}
+
+// NewReader creates a new PCAPNG Reader that reads from the given io.Reader.
+//
+// The first Section Header Block is read immediately. Files with multiple
+// sections, different byte orders and any number of interfaces are supported.
+// Files compressed with gzip or zstd are decompressed transparently.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+func NewReader(r io.Reader) (*Reader, error) {
+	r, err := compress.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading section header block: %v", err)
+	}
+	pr := &Reader{r: r}
+	if _, err := io.ReadFull(pr.r, pr.buf[:blockHeaderLen]); err != nil {
+		return nil, fmt.Errorf("error reading section header block: %v", err)
+	}
+	if binary.LittleEndian.Uint32(pr.buf[0:4]) != shbBlockType {
+		return nil, fmt.Errorf("file does not start with section header block")
+	}
+	if err := pr.readSHB(); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Section returns the current section.
+func (pr *Reader) Section() *Section {
+	return pr.section
+}
+
+// Interface returns the interface with the given ID in the current section.
+func (pr *Reader) Interface(id uint32) (*Interface, error) {
+	if int(id) >= len(pr.section.Interfaces) {
+		return nil, fmt.Errorf("unknown interface %d", id)
+	}
+	return pr.section.Interfaces[id], nil
+}
+
+// ReadPacket reads the next packet from the pcapng file.
+//
+// It returns the metadata of the packet and its captured data.
+// When there are no more packets, io.EOF is returned.
+func (pr *Reader) ReadPacket() (capture.Info, []byte, error) {
+	p, err := pr.NextPacket()
+	if err != nil {
+		return capture.Info{}, nil, err
+	}
+	in, err := pr.Interface(p.InterfaceID)
+	if err != nil {
+		return capture.Info{}, nil, err
+	}
+	ci := capture.Info{
+		Timestamp:   p.Timestamp,
+		InterfaceID: int(p.InterfaceID),
+		Length:      p.Length,
+		LinkType:    int(in.LinkType),
+		PacketType:  p.PacketType(),
+		Comment:     p.Comment(),
+	}
+	return ci, p.Data, nil
+}
+
+// PacketType returns the direction of the packet from its epb_flags option.
+func (p *Packet) PacketType() capture.PacketType {
+	return packetType(p.Flags)
+}
+
+// Comment returns the first opt_comment option of the packet or empty string if there is none.
+func (p *Packet) Comment() string {
+	return comment(p.Options)
+}
+
+// Comment returns the first opt_comment option of the section or empty string if there is none.
+func (s *Section) Comment() string {
+	return comment(s.Options)
+}
+
+// comment returns the value of the first opt_comment option.
+func comment(options []Option) string {
+	for _, opt := range options {
+		if opt.Code == optComment {
+			return string(opt.Value)
+		}
+	}
+	return ""
+}
+
+// packetType converts epb_flags option value to capture.PacketType.
+func packetType(flags uint32) capture.PacketType {
+	if flags&epbFlagsDirMask == epbFlagsOutbound {
+		return capture.PacketTypeOutgoing
+	}
+	switch flags & epbFlagsRecvMask {
+	case epbFlagsUnicast:
+		return capture.PacketTypeHost
+	case epbFlagsMulticast:
+		return capture.PacketTypeMulticast
+	case epbFlagsBroadcast:
+		return capture.PacketTypeBroadcast
+	case epbFlagsPromiscuous:
+		return capture.PacketTypeOtherHost
+	}
+	if flags&epbFlagsDirMask == epbFlagsInbound {
+		return capture.PacketTypeHost
+	}
+	return capture.PacketTypeUnknown
+}
+
+// NextPacket reads blocks until a packet block is found and returns the packet.
+//
+// Section Header, Interface Description, Interface Statistics, Name Resolution
+// and Decryption Secrets Blocks update
+// the state of the Reader, unknown blocks are skipped.
+// When there are no more packets, io.EOF is returned.
+func (pr *Reader) NextPacket() (*Packet, error) {
+	for {
+		if _, err := io.ReadFull(pr.r, pr.buf[:blockHeaderLen]); err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("error reading block header: %v", err)
+		}
+		blockType := pr.byteOrder.Uint32(pr.buf[0:4])
+		if blockType == shbBlockType {
+			if err := pr.readSHB(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		body, err := pr.readBody(pr.byteOrder.Uint32(pr.buf[4:8]), 0)
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case idbBlockType:
+			if err := pr.parseIDB(body); err != nil {
+				return nil, err
+			}
+		case isbBlockType:
+			if err := pr.parseISB(body); err != nil {
+				return nil, err
+			}
+		case nrbBlockType:
+			if err := pr.parseNRB(body); err != nil {
+				return nil, err
+			}
+		case dsbBlockType:
+			if err := pr.parseDSB(body); err != nil {
+				return nil, err
+			}
+		case epbBlockType:
+			return pr.parseEPB(body)
+		case spbBlockType:
+			return pr.parseSPB(body)
+		default:
+			// unknown or unsupported block, skipping
+		}
+	}
+}
+
+// readBody reads the block body and the trailing block length. The block header
+// and the consumed bytes of the body are expected to be already read.
+func (pr *Reader) readBody(blockLen, consumed uint32) ([]byte, error) {
+	if blockLen < blockHeaderLen+consumed+blockTrailerLen || blockLen%4 != 0 || blockLen > maxBlockLen {
+		return nil, fmt.Errorf("invalid block length %d", blockLen)
+	}
+	body := make([]byte, blockLen-blockHeaderLen-consumed)
+	if _, err := io.ReadFull(pr.r, body); err != nil {
+		return nil, fmt.Errorf("error reading block: %v", err)
+	}
+	trailer := body[len(body)-int(blockTrailerLen):]
+	if pr.byteOrder.Uint32(trailer) != blockLen {
+		return nil, fmt.Errorf("block length mismatch: %d != %d", pr.byteOrder.Uint32(trailer), blockLen)
+	}
+	return body[:len(body)-int(blockTrailerLen)], nil
+}
+
+// readSHB reads a Section Header Block (SHB), whose block type is already read.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#section_shb
+func (pr *Reader) readSHB() error {
+	if _, err := io.ReadFull(pr.r, pr.buf[blockHeaderLen:blockHeaderLen+byteOrderMagicLen]); err != nil {
+		return fmt.Errorf("error reading byte-order magic: %v", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(pr.buf[8:12]) == byteOrderMagic:
+		pr.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(pr.buf[8:12]) == byteOrderMagic:
+		pr.byteOrder = binary.BigEndian
+	default:
+		return fmt.Errorf("unknown byte-order magic %#08x", pr.buf[8:12])
+	}
+	body, err := pr.readBody(pr.byteOrder.Uint32(pr.buf[4:8]), byteOrderMagicLen)
+	if err != nil {
+		return err
+	}
+	if len(body) < 2+2+8 {
+		return fmt.Errorf("section header block is too short")
+	}
+	s := &Section{
+		VersionMajor: pr.byteOrder.Uint16(body[0:2]),
+		VersionMinor: pr.byteOrder.Uint16(body[2:4]),
+	}
+	if s.VersionMajor != versionMajor {
+		return fmt.Errorf("unsupported pcapng version %d.%d", s.VersionMajor, s.VersionMinor)
+	}
+	s.Options, err = pr.parseOptions(body[12:])
+	if err != nil {
+		return err
+	}
+	for _, opt := range s.Options {
+		switch opt.Code {
+		case shbHardwareCode:
+			s.Hardware = string(opt.Value)
+		case shbOSCode:
+			s.OS = string(opt.Value)
+		case shbUserAppCode:
+			s.UserAppl = string(opt.Value)
+		}
+	}
+	pr.section = s
+	return nil
+}
+
+// parseIDB parses an Interface Description Block (IDB) and adds the interface to the current section.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-interface-description-block
+func (pr *Reader) parseIDB(body []byte) error {
+	if len(body) < 2+2+4 {
+		return fmt.Errorf("interface description block is too short")
+	}
+	in := &Interface{
+		LinkType: pr.byteOrder.Uint16(body[0:2]),
+		Snaplen:  pr.byteOrder.Uint32(body[4:8]),
+		TsResol:  defaultTsResol,
+	}
+	var err error
+	in.Options, err = pr.parseOptions(body[8:])
+	if err != nil {
+		return err
+	}
+	for _, opt := range in.Options {
+		switch opt.Code {
+		case ifNameCode:
+			in.Name = string(opt.Value)
+		case ifDescCode:
+			in.Description = string(opt.Value)
+		case ifOSCode:
+			in.OS = string(opt.Value)
+		case ifFilterCode:
+			if len(opt.Value) > 0 {
+				in.Filter = string(opt.Value[1:])
+			}
+		case ifTsResCode:
+			if len(opt.Value) != 1 {
+				return fmt.Errorf("invalid if_tsresol length %d", len(opt.Value))
+			}
+			in.TsResol = opt.Value[0]
+		case ifTsOffsetCode:
+			if len(opt.Value) != 8 {
+				return fmt.Errorf("invalid if_tsoffset length %d", len(opt.Value))
+			}
+			in.TsOffset = int64(pr.byteOrder.Uint64(opt.Value))
+		}
+	}
+	if (in.TsResol&0x80 == 0 && in.TsResol > 19) || in.TsResol&0x7f > 63 {
+		return fmt.Errorf("unsupported if_tsresol %#02x", in.TsResol)
+	}
+	pr.section.Interfaces = append(pr.section.Interfaces, in)
+	return nil
+}
+
+// parseISB parses an Interface Statistics Block (ISB) and attaches it to its interface.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-interface-statistics-block
+func (pr *Reader) parseISB(body []byte) error {
+	if len(body) < 4+4+4 {
+		return fmt.Errorf("interface statistics block is too short")
+	}
+	in, err := pr.Interface(pr.byteOrder.Uint32(body[0:4]))
+	if err != nil {
+		return err
+	}
+	stats := &InterfaceStats{
+		Timestamp: in.timestamp(pr.byteOrder.Uint32(body[4:8]), pr.byteOrder.Uint32(body[8:12])),
+	}
+	stats.Options, err = pr.parseOptions(body[12:])
+	if err != nil {
+		return err
+	}
+	for _, opt := range stats.Options {
+		if len(opt.Value) != 8 {
+			continue
+		}
+		switch opt.Code {
+		case isbStartTimeCode:
+			stats.StartTime = in.timestamp(pr.byteOrder.Uint32(opt.Value[0:4]), pr.byteOrder.Uint32(opt.Value[4:8]))
+		case isbEndTimeCode:
+			stats.EndTime = in.timestamp(pr.byteOrder.Uint32(opt.Value[0:4]), pr.byteOrder.Uint32(opt.Value[4:8]))
+		case isbIfRecvCode:
+			stats.Received = pr.byteOrder.Uint64(opt.Value)
+		case isbIfDropCode:
+			stats.Dropped = pr.byteOrder.Uint64(opt.Value)
+		}
+	}
+	in.Stats = stats
+	return nil
+}
+
+// parseNRB parses a Name Resolution Block (NRB) and adds its IPv4 and IPv6 records to the section.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-name-resolution-block
+func (pr *Reader) parseNRB(body []byte) error {
+	for len(body) >= 4 {
+		recType := pr.byteOrder.Uint16(body[0:2])
+		length := int(pr.byteOrder.Uint16(body[2:4]))
+		if recType == nrbRecordEnd {
+			break
+		}
+		body = body[4:]
+		if length > len(body) {
+			return errors.New("name resolution record length exceeds block length")
+		}
+		value := body[:length]
+		body = body[min(length+pad(length), len(body)):]
+		var addrLen int
+		switch recType {
+		case nrbRecordIPv4:
+			addrLen = 4
+		case nrbRecordIPv6:
+			addrLen = 16
+		default:
+			continue
+		}
+		if len(value) < addrLen {
+			return fmt.Errorf("invalid name resolution record length %d", length)
+		}
+		addr, _ := netip.AddrFromSlice(value[:addrLen])
+		if pr.section.Hosts == nil {
+			pr.section.Hosts = make(map[netip.Addr][]string)
+		}
+		for _, name := range bytes.Split(value[addrLen:], zero) {
+			if len(name) > 0 && !slices.Contains(pr.section.Hosts[addr], string(name)) {
+				pr.section.Hosts[addr] = append(pr.section.Hosts[addr], string(name))
+			}
+		}
+	}
+	return nil
+}
+
+// parseDSB parses a Decryption Secrets Block (DSB) and adds it to the section.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-decryption-secrets-block
+func (pr *Reader) parseDSB(body []byte) error {
+	if len(body) < 4+4 {
+		return fmt.Errorf("decryption secrets block is too short")
+	}
+	secrets := &DecryptionSecrets{Type: pr.byteOrder.Uint32(body[0:4])}
+	length := pr.byteOrder.Uint32(body[4:8])
+	if length > uint32(len(body)-8) {
+		return fmt.Errorf("invalid secrets length %d", length)
+	}
+	secrets.Data = body[8 : 8+length]
+	optOffset := 8 + int(length) + pad(int(length))
+	if optOffset < len(body) {
+		var err error
+		secrets.Options, err = pr.parseOptions(body[optOffset:])
+		if err != nil {
+			return err
+		}
+	}
+	pr.section.Secrets = append(pr.section.Secrets, secrets)
+	return nil
+}
+
+// parseEPB parses an Enhanced Packet Block (EPB).
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-enhanced-packet-block
+func (pr *Reader) parseEPB(body []byte) (*Packet, error) {
+	if len(body) < 4+4+4+4+4 {
+		return nil, fmt.Errorf("enhanced packet block is too short")
+	}
+	p := &Packet{InterfaceID: pr.byteOrder.Uint32(body[0:4])}
+	in, err := pr.Interface(p.InterfaceID)
+	if err != nil {
+		return nil, err
+	}
+	p.Timestamp = in.timestamp(pr.byteOrder.Uint32(body[4:8]), pr.byteOrder.Uint32(body[8:12]))
+	capLen := pr.byteOrder.Uint32(body[12:16])
+	p.Length = int(pr.byteOrder.Uint32(body[16:20]))
+	if capLen > uint32(len(body)-20) {
+		return nil, fmt.Errorf("invalid captured packet length %d", capLen)
+	}
+	p.CaptureLength = int(capLen)
+	p.Data = body[20 : 20+capLen]
+	optOffset := 20 + int(capLen) + pad(int(capLen))
+	if optOffset < len(body) {
+		p.Options, err = pr.parseOptions(body[optOffset:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range p.Options {
+		if opt.Code == epbFlagsCode && len(opt.Value) == 4 {
+			p.Flags = pr.byteOrder.Uint32(opt.Value)
+		}
+	}
+	return p, nil
+}
+
+// parseSPB parses a Simple Packet Block (SPB). Packets from SPB always belong to the first interface.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-simple-packet-block
+func (pr *Reader) parseSPB(body []byte) (*Packet, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("simple packet block is too short")
+	}
+	in, err := pr.Interface(0)
+	if err != nil {
+		return nil, err
+	}
+	p := &Packet{Length: int(pr.byteOrder.Uint32(body[0:4]))}
+	capLen := len(body) - 4
+	if p.Length < capLen {
+		capLen = p.Length
+	}
+	if in.Snaplen > 0 && int(in.Snaplen) < capLen {
+		capLen = int(in.Snaplen)
+	}
+	p.CaptureLength = capLen
+	p.Data = body[4 : 4+capLen]
+	return p, nil
+}
+
+// parseOptions parses options until opt_endofopt or the end of data.
+func (pr *Reader) parseOptions(data []byte) ([]Option, error) {
+	var options []Option
+	for len(data) >= 4 {
+		code := pr.byteOrder.Uint16(data[0:2])
+		length := int(pr.byteOrder.Uint16(data[2:4]))
+		if code == optEndOfOpt {
+			break
+		}
+		data = data[4:]
+		if length > len(data) {
+			return nil, errors.New("option length exceeds block length")
+		}
+		options = append(options, Option{Code: code, Value: data[:length]})
+		data = data[min(length+pad(length), len(data)):]
+	}
+	return options, nil
+}
+
+// timestamp converts timestamp in units of if_tsresol to time.Time.
+func (in *Interface) timestamp(high, low uint32) time.Time {
+	ts := uint64(high)<<32 | uint64(low)
+	var secs, nsecs uint64
+	if in.TsResol&0x80 == 0 {
+		unit := uint64(1)
+		for i := uint8(0); i < in.TsResol; i++ {
+			unit *= 10
+		}
+		secs = ts / unit
+		if rem := ts % unit; unit <= 1e9 {
+			nsecs = rem * (1e9 / unit)
+		} else {
+			nsecs = rem / (unit / 1e9)
+		}
+	} else if shift := uint(in.TsResol & 0x7f); shift > 0 {
+		secs = ts >> shift
+		hi, lo := bits.Mul64(ts&(1<<shift-1), 1e9)
+		nsecs = hi<<(64-shift) | lo>>shift
+	} else {
+		secs = ts
+	}
+	return time.Unix(int64(secs)+in.TsOffset, int64(nsecs)).UTC()
+}
@@ -0,0 +1,493 @@
+package mpcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func testBlock(order binary.ByteOrder, blockType uint32, body []byte) []byte {
+	blockLen := uint32(4 + 4 + len(body) + pad(len(body)) + 4)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, order, blockType)
+	binary.Write(buf, order, blockLen)
+	buf.Write(body)
+	buf.Write(bytes.Repeat(zero, pad(len(body))))
+	binary.Write(buf, order, blockLen)
+	return buf.Bytes()
+}
+
+func testOption(order binary.ByteOrder, code uint16, value []byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, order, code)
+	binary.Write(buf, order, uint16(len(value)))
+	buf.Write(value)
+	buf.Write(bytes.Repeat(zero, pad(len(value))))
+	return buf.Bytes()
+}
+
+func testSHB(order binary.ByteOrder, options ...[]byte) []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, order, byteOrderMagic)
+	binary.Write(body, order, versionMajor)
+	binary.Write(body, order, versionMinor)
+	binary.Write(body, order, sectionLen)
+	for _, opt := range options {
+		body.Write(opt)
+	}
+	return testBlock(order, shbBlockType, body.Bytes())
+}
+
+func testIDB(order binary.ByteOrder, linkType uint16, snaplen uint32, options ...[]byte) []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, order, linkType)
+	binary.Write(body, order, reserved)
+	binary.Write(body, order, snaplen)
+	for _, opt := range options {
+		body.Write(opt)
+	}
+	return testBlock(order, idbBlockType, body.Bytes())
+}
+
+func testEPB(order binary.ByteOrder, id uint32, ts uint64, data []byte, options ...[]byte) []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, order, id)
+	binary.Write(body, order, uint32(ts>>32))
+	binary.Write(body, order, uint32(ts))
+	binary.Write(body, order, uint32(len(data)))
+	binary.Write(body, order, uint32(len(data)+10))
+	body.Write(data)
+	body.Write(bytes.Repeat(zero, pad(len(data))))
+	for _, opt := range options {
+		body.Write(opt)
+	}
+	return testBlock(order, epbBlockType, body.Bytes())
+}
+
+func TestReadWriterOutput(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	in := &net.Interface{Index: 0, Name: "any"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "port 53", 1600); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2024, 9, 17, 9, 37, 50, 123456e3, time.UTC)
+	packets := [][]byte{{0xde, 0xad, 0xbe, 0xef}, {0x01, 0x02, 0x03}}
+	for _, p := range packets {
+		if err := w.WritePacket(capture.Info{Timestamp: timestamp}, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "mshark", r.Section().UserAppl)
+	for _, p := range packets {
+		ci, data, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, timestamp, ci.Timestamp)
+		require.Equal(t, p, data)
+	}
+	_, _, err = r.ReadPacket()
+	require.ErrorIs(t, err, io.EOF)
+	ifc, err := r.Interface(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "any", ifc.Name)
+	require.Equal(t, "port 53", ifc.Filter)
+	require.Equal(t, uint32(1600), ifc.Snaplen)
+	require.Equal(t, timeResMicro, ifc.TsResol)
+}
+
+func TestReadWriterNanosecond(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterPrecision(&buf, capture.Nanosecond)
+	in := &net.Interface{Index: 0, Name: "any"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 1600); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2024, 9, 17, 9, 37, 50, 123456789, time.UTC)
+	if err := w.WritePacket(capture.Info{Timestamp: timestamp}, []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, _, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, timestamp, ci.Timestamp)
+	ifc, err := r.Interface(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, timeResNano, ifc.TsResol)
+}
+
+func TestReadWriterTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	in := &net.Interface{Index: 0, Name: "any"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(capture.Info{Length: 1500}, []byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, 3, p.CaptureLength)
+	require.Equal(t, 1500, p.Length)
+}
+
+func TestReadWriterPacketType(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	in := &net.Interface{Index: 0, Name: "any"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	types := []capture.PacketType{
+		capture.PacketTypeUnknown,
+		capture.PacketTypeHost,
+		capture.PacketTypeBroadcast,
+		capture.PacketTypeMulticast,
+		capture.PacketTypeOtherHost,
+		capture.PacketTypeOutgoing,
+	}
+	for _, pt := range types {
+		if err := w.WritePacket(capture.Info{PacketType: pt}, []byte{byte(pt)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pt := range types {
+		ci, data, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, pt, ci.PacketType)
+		require.Equal(t, []byte{byte(pt)}, data)
+	}
+	// epb_flags with inbound direction and unspecified reception type
+	require.Equal(t, capture.PacketTypeHost, packetType(epbFlagsInbound))
+}
+
+func TestReadWriterComments(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetComment("incident #42")
+	in := &net.Interface{Index: 1, Name: "eth0"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	infos := []capture.Info{
+		{},
+		{Comment: "incident started", PacketType: capture.PacketTypeOutgoing},
+		{Comment: "odd"},
+	}
+	for _, ci := range infos {
+		if err := w.WritePacket(ci, []byte{0xaa, 0xbb}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "incident #42", r.Section().Comment())
+	require.Equal(t, "mshark", r.Section().UserAppl)
+	for _, expected := range infos {
+		ci, data, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, expected.Comment, ci.Comment)
+		require.Equal(t, expected.PacketType, ci.PacketType)
+		require.Equal(t, []byte{0xaa, 0xbb}, data)
+	}
+}
+
+func TestReadWriterMultipleInterfaces(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	ins := []*net.Interface{
+		{Index: 1, Name: "eth0", HardwareAddr: net.HardwareAddr{0x02, 0xfc, 0, 0, 0, 1}},
+		{Index: 2, Name: "eth1", HardwareAddr: net.HardwareAddr{0x02, 0xfc, 0, 0, 0, 2}},
+	}
+	linkTypes := []uint16{capture.LinkTypeEthernet, capture.LinkTypeLinuxSLL2}
+	require.Error(t, w.WriteHeaderLinkTypes("mshark", ins, linkTypes[:1], "", 65535))
+	if err := w.WriteHeaderLinkTypes("mshark", ins, linkTypes, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 0, 1} {
+		if err := w.WritePacket(capture.Info{InterfaceID: id}, []byte{byte(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	require.Error(t, w.WritePacket(capture.Info{InterfaceID: 2}, []byte{2}))
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 0, 1} {
+		ci, data, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, id, ci.InterfaceID)
+		require.Equal(t, int(linkTypes[id]), ci.LinkType)
+		require.Equal(t, []byte{byte(id)}, data)
+	}
+	require.Len(t, r.Section().Interfaces, 2)
+	require.Equal(t, "eth1", r.Section().Interfaces[1].Name)
+}
+
+func TestReadMultipleSections(t *testing.T) {
+	be, le := binary.BigEndian, binary.LittleEndian
+	var file []byte
+	// big-endian section with two interfaces of different resolution
+	file = append(file, testSHB(be, testOption(be, shbUserAppCode, []byte("test")))...)
+	file = append(file, testIDB(be, 1, 65535, testOption(be, ifNameCode, []byte("eth0")))...)
+	file = append(file, testIDB(be, 101, 1500, testOption(be, ifNameCode, []byte("wg0")), testOption(be, ifTsResCode, []byte{9}))...)
+	file = append(file, testBlock(be, 0x0bad, []byte{1, 2, 3, 4})...)
+	file = append(file, testEPB(be, 1, 1726565870123456789, []byte{0xaa, 0xbb}, testOption(be, optComment, []byte("hello")))...)
+	// little-endian section with a simple packet block and power of two resolution
+	file = append(file, testSHB(le)...)
+	file = append(file, testIDB(le, 1, 4, testOption(le, ifTsResCode, []byte{0x80 | 10}))...)
+	spb := new(bytes.Buffer)
+	binary.Write(spb, le, uint32(6))
+	spb.Write([]byte{1, 2, 3, 4})
+	file = append(file, testBlock(le, spbBlockType, spb.Bytes())...)
+	file = append(file, testEPB(le, 0, 3<<10|512, []byte{0xcc})...)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "test", r.Section().UserAppl)
+
+	p, err := r.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, &Packet{
+		InterfaceID:   1,
+		Timestamp:     time.Unix(1726565870, 123456789).UTC(),
+		CaptureLength: 2,
+		Length:        12,
+		Data:          []byte{0xaa, 0xbb},
+		Options:       []Option{{Code: optComment, Value: []byte("hello")}},
+	}, p)
+	ifc, err := r.Interface(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "wg0", ifc.Name)
+	require.Equal(t, uint16(101), ifc.LinkType)
+
+	p, err = r.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, uint32(0), p.InterfaceID)
+	require.Equal(t, 6, p.Length)
+	require.Equal(t, []byte{1, 2, 3, 4}, p.Data)
+	require.Len(t, r.Section().Interfaces, 1)
+
+	p, err = r.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, time.Unix(3, 5e8).UTC(), p.Timestamp)
+	_, err = r.NextPacket()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadInterfaceStats(t *testing.T) {
+	be := binary.BigEndian
+	var file []byte
+	file = append(file, testSHB(be)...)
+	file = append(file, testIDB(be, 1, 65535)...)
+	isb := new(bytes.Buffer)
+	binary.Write(isb, be, uint32(0))
+	binary.Write(isb, be, uint64(1726565870000000))
+	isb.Write(testOption(be, isbIfRecvCode, binary.BigEndian.AppendUint64(nil, 42)))
+	isb.Write(testOption(be, isbIfDropCode, binary.BigEndian.AppendUint64(nil, 7)))
+	file = append(file, testBlock(be, isbBlockType, isb.Bytes())...)
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.NextPacket()
+	require.ErrorIs(t, err, io.EOF)
+	ifc, err := r.Interface(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, time.Unix(1726565870, 0).UTC(), ifc.Stats.Timestamp)
+	require.Equal(t, uint64(42), ifc.Stats.Received)
+	require.Equal(t, uint64(7), ifc.Stats.Dropped)
+}
+
+func TestReadWriterStats(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterPrecision(&buf, capture.Nanosecond)
+	in := &net.Interface{Index: 1, Name: "eth0"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1726565870, 123456789).UTC()
+	stats := capture.Stats{StartTime: start, EndTime: start.Add(time.Minute), Received: 42, Dropped: 7}
+	if err := w.WriteStats(stats); err != nil {
+		t.Fatal(err)
+	}
+	require.Error(t, w.WriteStats(capture.Stats{InterfaceID: 1}))
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.NextPacket()
+	require.ErrorIs(t, err, io.EOF)
+	ifc, err := r.Interface(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, &InterfaceStats{
+		Timestamp: stats.EndTime,
+		StartTime: stats.StartTime,
+		EndTime:   stats.EndTime,
+		Received:  42,
+		Dropped:   7,
+		Options:   ifc.Stats.Options,
+	}, ifc.Stats)
+	require.Len(t, ifc.Stats.Options, 4)
+}
+
+func TestReadWriterNameResolution(t *testing.T) {
+	dns, err := os.ReadFile("../layers/testdata/dns.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw IPv4 packet with UDP datagram from port 53
+	packet := []byte{
+		0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x08, 0x08, 0x08, 0x08, 0x0a, 0x00, 0x00, 0x01,
+		0x00, 0x35, 0xc3, 0x50, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)+len(dns)))
+	binary.BigEndian.PutUint16(packet[24:26], uint16(8+len(dns)))
+	packet = append(packet, dns...)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.ResolveNames()
+	in := &net.Interface{Index: 1, Name: "wg0"}
+	if err := w.WriteHeaderLinkTypes("mshark", []*net.Interface{in}, []uint16{capture.LinkTypeRaw}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	ci := capture.Info{LinkType: capture.LinkTypeRaw}
+	var sizes []int
+	for range 2 {
+		size := buf.Len()
+		if err := w.WritePacket(ci, packet); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, buf.Len()-size)
+	}
+	// the first packet is followed by NRB, names already written are not repeated
+	require.Greater(t, sizes[0], sizes[1])
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, _, err := r.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = r.NextPacket()
+	require.ErrorIs(t, err, io.EOF)
+	hosts := r.Section().Hosts
+	require.Len(t, hosts, 7)
+	require.Equal(t, []string{"www.googleapis.com"}, hosts[netip.AddrFrom4([4]byte{0x8e, 0xfa, 0x4a, 0x4a})])
+}
+
+func TestReadWriterKeyLog(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "sslkeylog.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("CLIENT_RANDOM 01 02\n"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.EmbedKeyLog(f)
+	in := &net.Interface{Index: 1, Name: "eth0"}
+	if err := w.WriteHeader("mshark", []*net.Interface{in}, "", 65535); err != nil {
+		t.Fatal(err)
+	}
+	ci := capture.Info{}
+	if err := w.WritePacket(ci, make([]byte, 14)); err != nil {
+		t.Fatal(err)
+	}
+	// the key log is not read for every packet, lines appended during the capture
+	// are written with statistics at the latest, incomplete lines are held back
+	if _, err := f.WriteString("CLIENT_RANDOM 03 04\nSERVER_HANDSHAKE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(ci, make([]byte, 14)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteStats(capture.Stats{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, _, err := r.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	require.Len(t, r.Section().Secrets, 1)
+	if _, _, err := r.ReadPacket(); err != io.EOF {
+		t.Fatal(err)
+	}
+	secrets := r.Section().Secrets
+	require.Len(t, secrets, 2)
+	for _, s := range secrets {
+		require.Equal(t, SecretsTypeTLSKeyLog, s.Type)
+	}
+	require.Equal(t, "CLIENT_RANDOM 01 02\n", string(secrets[0].Data))
+	require.Equal(t, "CLIENT_RANDOM 03 04\n", string(secrets[1].Data))
+}
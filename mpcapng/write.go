@@ -3,12 +3,16 @@ package mpcapng
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os/exec"
 	"time"
 
-	"github.com/shadowy-pycoder/mshark/native"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/layers"
+	"github.com/shadowy-pycoder/mshark/v2/native"
 )
 
 // https://pcapng.com/
@@ -24,7 +28,8 @@ const (
 	idbBlockType    uint32 = 0x00000001
 	linkType        uint16 = 1
 	reserved        uint16 = 0
-	timeRes         uint8  = 0x03
+	timeResMicro    uint8  = 0x06
+	timeResNano     uint8  = 0x09
 	ifNameCode      uint16 = 0x0002
 	ifDescCode      uint16 = 0x0003
 	ifMACCode       uint16 = 0x0006
@@ -32,7 +37,24 @@ const (
 	ifFilterCode    uint16 = 0x000b
 	ifOSCode        uint16 = 0x000c
 	epbBlockType    uint32 = 0x00000006
-	interfaceId     uint32 = 0x00000000 // only support one IDB
+	epbFlagsCode    uint16 = 0x0002
+
+	// SecretsTypeTLSKeyLog is the secrets type of TLS key log files (SSLKEYLOGFILE) in Decryption Secrets Blocks.
+	//
+	// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-decryption-secrets-block
+	SecretsTypeTLSKeyLog uint32 = 0x544c534b
+)
+
+// Direction and reception type bits of epb_flags option.
+const (
+	epbFlagsInbound     uint32 = 1
+	epbFlagsOutbound    uint32 = 2
+	epbFlagsUnicast     uint32 = 1 << 2
+	epbFlagsMulticast   uint32 = 2 << 2
+	epbFlagsBroadcast   uint32 = 3 << 2
+	epbFlagsPromiscuous uint32 = 4 << 2
+	epbFlagsDirMask     uint32 = 0x3
+	epbFlagsRecvMask    uint32 = 0x7 << 2
 )
 
 var (
@@ -41,25 +63,93 @@ var (
 )
 
 type Writer struct {
-	w io.Writer
+	w          io.Writer
+	precision  capture.Precision
+	interfaces int
+	hosts      map[hostName]struct{} // host names written to NRBs, nil if name resolution is disabled
+	keyLog     *keyLog
+	comment    string // opt_comment of the section
+}
+
+// keyLogInterval is how often the key log is checked for new lines while packets are written.
+const keyLogInterval = time.Second
+
+// keyLog tracks the part of TLS key log file already embedded into the file.
+type keyLog struct {
+	r       io.ReaderAt
+	offset  int64
+	pending []byte    // incomplete line at the end of the file
+	polled  time.Time // the time the key log was last read
+	buf     []byte
+}
+
+// hostName is an address with one of its names.
+type hostName struct {
+	addr netip.Addr
+	name string
 }
 
 // NewWriter creates a new PCAPNG Writer that writes to the given io.Writer.
+//
+// Timestamps are written with microsecond resolution.
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{w: w}
 }
 
+// NewWriterPrecision is like NewWriter but writes timestamps with the given resolution.
+//
+// The resolution is advertised in the if_tsresol option of every interface.
+func NewWriterPrecision(w io.Writer, p capture.Precision) *Writer {
+	return &Writer{w: w, precision: p}
+}
+
+// timeRes returns the value of if_tsresol option.
+func (pw *Writer) timeRes() uint8 {
+	if pw.precision == capture.Nanosecond {
+		return timeResNano
+	}
+	return timeResMicro
+}
+
+// timestamp returns the timestamp in units of if_tsresol.
+func (pw *Writer) timestamp(t time.Time) uint64 {
+	if pw.precision == capture.Nanosecond {
+		return uint64(t.UnixNano())
+	}
+	return uint64(t.UnixMicro())
+}
+
 // WriteHeader writes a Section Header Block (SHB) and an Interface Description Block (IDB)
-// to the pcapng file.
+// for each interface to the pcapng file.
 //
-// The SHB contains metadata about the capture, and the IDB describes the interface
-// that the packets were captured on.
-func (pw *Writer) WriteHeader(app string, in *net.Interface, expr string, snaplen int) error {
+// The SHB contains metadata about the capture, and the IDBs describe the interfaces
+// that the packets were captured on. Interface IDs are assigned in the order
+// the interfaces are given. All interfaces are described as Ethernet.
+func (pw *Writer) WriteHeader(app string, ins []*net.Interface, expr string, snaplen int) error {
+	linkTypes := make([]uint16, len(ins))
+	for i := range linkTypes {
+		linkTypes[i] = linkType
+	}
+	return pw.WriteHeaderLinkTypes(app, ins, linkTypes, expr, snaplen)
+}
+
+// WriteHeaderLinkTypes is like WriteHeader but with the link-layer header type
+// of each interface given in linkTypes.
+func (pw *Writer) WriteHeaderLinkTypes(app string, ins []*net.Interface, linkTypes []uint16, expr string, snaplen int) error {
+	if len(linkTypes) != len(ins) {
+		return fmt.Errorf("got %d link types for %d interfaces", len(linkTypes), len(ins))
+	}
 	if err := pw.writeSHB(app); err != nil {
 		return err
 	}
-	if err := pw.writeIDB(in, expr, snaplen); err != nil {
-		return err
+	for i, in := range ins {
+		if err := pw.writeIDB(in, linkTypes[i], expr, snaplen); err != nil {
+			return err
+		}
+	}
+	pw.interfaces = len(ins)
+	if pw.keyLog != nil {
+		return pw.writeKeyLog()
 	}
 	return nil
 }
@@ -86,6 +176,14 @@ func (pw *Writer) writeSHB(app string) error {
 	return err
 }
 
+// writeOption writes an option with the given code and value followed by padding.
+func writeOption(buf *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(buf, nativeEndian, code)
+	binary.Write(buf, nativeEndian, uint16(len(value)))
+	buf.Write(value)
+	buf.Write(bytes.Repeat(zero, pad(len(value))))
+}
+
 func pad(size int) int {
 	return (4 - (size & 3)) & 3
 }
@@ -115,7 +213,14 @@ func (pw *Writer) writeShbOptions(app string) ([]byte, error) {
 	osPad := pad(len(osinfo))
 	appLen := len(app)
 	userPad := pad(len(app))
-	buflen := (2 + // shb_hardware code
+	commentLen := len(pw.comment)
+	commentPad := pad(commentLen)
+	if commentLen > 0 {
+		commentLen += 4 // opt_comment code and length
+	}
+	buflen := (commentLen + // opt_comment
+		commentPad + // padding
+		2 + // shb_hardware code
 		2 + // shb_hardware length
 		hwLen + // shb_hardware
 		hPad + // padding
@@ -130,6 +235,9 @@ func (pw *Writer) writeShbOptions(app string) ([]byte, error) {
 		2 + // opt_endofopt
 		2) // opt_endofopt length (must be 0)
 	buf := bytes.NewBuffer(make([]byte, 0, buflen))
+	if pw.comment != "" {
+		writeOption(buf, optComment, []byte(pw.comment))
+	}
 	binary.Write(buf, nativeEndian, shbHardwareCode)
 	binary.Write(buf, nativeEndian, uint16(hwLen))
 	binary.Write(buf, nativeEndian, hwinfo)
@@ -149,7 +257,7 @@ func (pw *Writer) writeShbOptions(app string) ([]byte, error) {
 // writeIDB writes an Interface Description Block (IDB) to the  file.
 //
 // https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-interface-description-block
-func (pw *Writer) writeIDB(in *net.Interface, expr string, snaplen int) error {
+func (pw *Writer) writeIDB(in *net.Interface, linkType uint16, expr string, snaplen int) error {
 	options, err := pw.writeIdbOptions(in, expr)
 	if err != nil {
 		return err
@@ -217,7 +325,7 @@ func (pw *Writer) writeIdbOptions(in *net.Interface, expr string) ([]byte, error
 	buf.Write(bytes.Repeat(zero, ifNamePad))
 	binary.Write(buf, nativeEndian, ifMACCode)
 	binary.Write(buf, nativeEndian, uint16(6))
-	if in.Name == "any" || in.Index == 0 {
+	if in.Name == "any" || in.Index == 0 || len(in.HardwareAddr) != 6 {
 		buf.Write(bytes.Repeat(zero, 6))
 	} else {
 		binary.Write(buf, nativeEndian, in.HardwareAddr)
@@ -225,7 +333,7 @@ func (pw *Writer) writeIdbOptions(in *net.Interface, expr string) ([]byte, error
 	buf.Write(bytes.Repeat(zero, 2))
 	binary.Write(buf, nativeEndian, ifTsResCode)
 	binary.Write(buf, nativeEndian, uint16(1))
-	binary.Write(buf, nativeEndian, timeRes)
+	binary.Write(buf, nativeEndian, pw.timeRes())
 	buf.Write(bytes.Repeat(zero, 3))
 	binary.Write(buf, nativeEndian, ifFilterCode)
 	binary.Write(buf, nativeEndian, uint16(exprLen))
@@ -242,22 +350,268 @@ func (pw *Writer) writeIdbOptions(in *net.Interface, expr string) ([]byte, error
 
 // WritePacket writes an Enhanced Packet Block (EPB) to the  file.
 //
+// The interface ID, the original packet length and the direction of the packet (epb_flags option)
+// of the EPB are taken from the capture info.
+// The interface ID must refer to one of the interfaces written by WriteHeader.
+//
 // https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-enhanced-packet-block
-func (pw *Writer) WritePacket(timestamp time.Time, data []byte) error {
+func (pw *Writer) WritePacket(ci capture.Info, data []byte) error {
+	if ci.InterfaceID < 0 || ci.InterfaceID >= pw.interfaces {
+		return fmt.Errorf("unknown interface ID %d", ci.InterfaceID)
+	}
+	if pw.keyLog != nil && time.Since(pw.keyLog.polled) >= keyLogInterval {
+		if err := pw.writeKeyLog(); err != nil {
+			return err
+		}
+	}
 	packetLen := len(data)
-	blockLen := 4 + 4 + 4 + 4 + 4 + 4 + 4 + packetLen + 4
+	packetPad := pad(packetLen)
+	var options bytes.Buffer
+	if ci.Comment != "" {
+		writeOption(&options, optComment, []byte(ci.Comment))
+	}
+	if flags := epbFlags(ci.PacketType); flags != 0 {
+		value := make([]byte, 4)
+		nativeEndian.PutUint32(value, flags)
+		writeOption(&options, epbFlagsCode, value)
+	}
+	if options.Len() > 0 {
+		binary.Write(&options, nativeEndian, optEndOfOpt)
+		binary.Write(&options, nativeEndian, uint16(0))
+	}
+	blockLen := 4 + 4 + 4 + 4 + 4 + 4 + 4 + packetLen + packetPad + options.Len() + 4
 	binary.Write(pw.w, nativeEndian, epbBlockType)
 	binary.Write(pw.w, nativeEndian, uint32(blockLen))
-	binary.Write(pw.w, nativeEndian, interfaceId)
-	msecs := uint64(timestamp.UnixMilli())
-	binary.Write(pw.w, nativeEndian, uint32(msecs>>32))
-	binary.Write(pw.w, nativeEndian, uint32(msecs&(1<<32-1)))
-	binary.Write(pw.w, nativeEndian, uint32(packetLen))
+	binary.Write(pw.w, nativeEndian, uint32(ci.InterfaceID))
+	ts := pw.timestamp(ci.Timestamp)
+	binary.Write(pw.w, nativeEndian, uint32(ts>>32))
+	binary.Write(pw.w, nativeEndian, uint32(ts&(1<<32-1)))
 	binary.Write(pw.w, nativeEndian, uint32(packetLen))
+	binary.Write(pw.w, nativeEndian, uint32(ci.OriginalLength(data)))
 	if _, err := pw.w.Write(data); err != nil {
 		return err
 	}
-	pw.w.Write(bytes.Repeat(zero, pad(packetLen)))
+	pw.w.Write(bytes.Repeat(zero, packetPad))
+	pw.w.Write(options.Bytes())
 	binary.Write(pw.w, nativeEndian, uint32(blockLen))
+	if pw.hosts != nil {
+		if hosts := pw.learnHosts(ci, data); len(hosts) > 0 {
+			return pw.writeNRB(hosts)
+		}
+	}
+	return nil
+}
+
+// SetComment sets the comment of the section written by WriteHeader,
+// e.g. the purpose of the capture. Empty comment is not written.
+func (pw *Writer) SetComment(comment string) {
+	pw.comment = comment
+}
+
+// EmbedKeyLog makes the Writer embed TLS key log file (SSLKEYLOGFILE) into Decryption Secrets Blocks (DSB),
+// so that TLS sessions in the file can be decrypted without shipping the key log separately.
+//
+// The contents of the key log are written after the header. Lines appended to it later
+// are written before the next packet once a second and with interface statistics,
+// including the final ones, so that keys logged at the end of the capture are not lost.
+// Only complete lines are written.
+// EmbedKeyLog must be called before WriteHeader.
+func (pw *Writer) EmbedKeyLog(r io.ReaderAt) {
+	pw.keyLog = &keyLog{r: r, buf: make([]byte, 4096)}
+}
+
+// writeKeyLog writes lines appended to the key log since the last call in a DSB.
+func (pw *Writer) writeKeyLog() error {
+	kl := pw.keyLog
+	kl.polled = time.Now()
+	for {
+		n, err := kl.r.ReadAt(kl.buf, kl.offset)
+		kl.offset += int64(n)
+		kl.pending = append(kl.pending, kl.buf[:n]...)
+		if err == io.EOF || (err == nil && n < len(kl.buf)) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read key log: %v", err)
+		}
+	}
+	i := bytes.LastIndexByte(kl.pending, '\n')
+	if i < 0 {
+		return nil
+	}
+	if err := pw.writeDSB(SecretsTypeTLSKeyLog, kl.pending[:i+1]); err != nil {
+		return err
+	}
+	kl.pending = append(kl.pending[:0], kl.pending[i+1:]...)
+	return nil
+}
+
+// writeDSB writes a Decryption Secrets Block (DSB) with the given secrets.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-decryption-secrets-block
+func (pw *Writer) writeDSB(secretsType uint32, secrets []byte) error {
+	secretsPad := pad(len(secrets))
+	blockLen := 4 + 4 + 4 + 4 + len(secrets) + secretsPad + 4
+	buf := bytes.NewBuffer(make([]byte, 0, blockLen))
+	binary.Write(buf, nativeEndian, dsbBlockType)
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	binary.Write(buf, nativeEndian, secretsType)
+	binary.Write(buf, nativeEndian, uint32(len(secrets)))
+	buf.Write(secrets)
+	buf.Write(bytes.Repeat(zero, secretsPad))
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	_, err := pw.w.Write(buf.Bytes())
+	return err
+}
+
+// ResolveNames enables writing Name Resolution Blocks (NRB) with host names of IPv4 and IPv6
+// addresses learned from DNS answers in written packets.
+//
+// Each packet with new names is followed by NRB with them, so that tools opening
+// the file later show host names resolved at capture time.
+func (pw *Writer) ResolveNames() {
+	if pw.hosts == nil {
+		pw.hosts = make(map[hostName]struct{})
+	}
+}
+
+// learnHosts decodes the packet and returns addresses and names from DNS answers
+// that were not written yet.
+func (pw *Writer) learnHosts(ci capture.Info, data []byte) []layers.DNSHost {
+	name, payload, err := layers.FirstLayer(ci.LinkType, data)
+	if err != nil {
+		return nil
+	}
+	for len(payload) > 0 {
+		switch name {
+		case "ETH", "SLL", "SLL2", "IPv4", "IPv6", "UDP", "TCP", "DNS":
+		default:
+			return nil
+		}
+		l := layers.NewLayer(name)
+		if err := l.Parse(payload); err != nil {
+			return nil
+		}
+		if dns, ok := l.(*layers.DNSMessage); ok {
+			var hosts []layers.DNSHost
+			for _, host := range dns.Hosts() {
+				var names []string
+				for _, name := range host.Names {
+					hn := hostName{addr: host.Addr.Unmap(), name: name}
+					if _, ok := pw.hosts[hn]; !ok {
+						pw.hosts[hn] = struct{}{}
+						names = append(names, name)
+					}
+				}
+				if len(names) > 0 {
+					hosts = append(hosts, layers.DNSHost{Addr: host.Addr.Unmap(), Names: names})
+				}
+			}
+			return hosts
+		}
+		name, payload = l.NextLayer()
+	}
 	return nil
 }
+
+// writeNRB writes a Name Resolution Block (NRB) with the given addresses and their names.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-name-resolution-block
+func (pw *Writer) writeNRB(hosts []layers.DNSHost) error {
+	records := new(bytes.Buffer)
+	for _, host := range hosts {
+		recType := nrbRecordIPv4
+		if host.Addr.Is6() {
+			recType = nrbRecordIPv6
+		}
+		value := host.Addr.AsSlice()
+		for _, name := range host.Names {
+			value = append(value, name...)
+			value = append(value, 0)
+		}
+		binary.Write(records, nativeEndian, recType)
+		binary.Write(records, nativeEndian, uint16(len(value)))
+		records.Write(value)
+		records.Write(bytes.Repeat(zero, pad(len(value))))
+	}
+	binary.Write(records, nativeEndian, nrbRecordEnd)
+	binary.Write(records, nativeEndian, uint16(0))
+	blockLen := 4 + 4 + records.Len() + 4
+	buf := bytes.NewBuffer(make([]byte, 0, blockLen))
+	binary.Write(buf, nativeEndian, nrbBlockType)
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	buf.Write(records.Bytes())
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	_, err := pw.w.Write(buf.Bytes())
+	return err
+}
+
+// WriteStats writes an Interface Statistics Block (ISB) with the number of packets
+// received and dropped on the interface since the start of capture.
+// Lines appended to the embedded key log are written before it.
+//
+// The interface ID must refer to one of the interfaces written by WriteHeader.
+//
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html#name-interface-statistics-block
+func (pw *Writer) WriteStats(stats capture.Stats) error {
+	if stats.InterfaceID < 0 || stats.InterfaceID >= pw.interfaces {
+		return fmt.Errorf("unknown interface ID %d", stats.InterfaceID)
+	}
+	if pw.keyLog != nil {
+		if err := pw.writeKeyLog(); err != nil {
+			return err
+		}
+	}
+	optLen := 4*(4+8) + 4 // isb_starttime, isb_endtime, isb_ifrecv, isb_ifdrop and opt_endofopt
+	blockLen := 4 + 4 + 4 + 4 + 4 + optLen + 4
+	buf := bytes.NewBuffer(make([]byte, 0, blockLen))
+	binary.Write(buf, nativeEndian, isbBlockType)
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	binary.Write(buf, nativeEndian, uint32(stats.InterfaceID))
+	ts := pw.timestamp(stats.EndTime)
+	binary.Write(buf, nativeEndian, uint32(ts>>32))
+	binary.Write(buf, nativeEndian, uint32(ts&(1<<32-1)))
+	for _, opt := range []struct {
+		code  uint16
+		value uint64
+	}{
+		{isbStartTimeCode, pw.timestamp(stats.StartTime)},
+		{isbEndTimeCode, ts},
+		{isbIfRecvCode, stats.Received},
+		{isbIfDropCode, stats.Dropped},
+	} {
+		binary.Write(buf, nativeEndian, opt.code)
+		binary.Write(buf, nativeEndian, uint16(8))
+		if opt.code == isbStartTimeCode || opt.code == isbEndTimeCode {
+			// timestamps are stored as high and low 32 bits like in EPB
+			binary.Write(buf, nativeEndian, uint32(opt.value>>32))
+			binary.Write(buf, nativeEndian, uint32(opt.value&(1<<32-1)))
+		} else {
+			binary.Write(buf, nativeEndian, opt.value)
+		}
+	}
+	binary.Write(buf, nativeEndian, optEndOfOpt)
+	binary.Write(buf, nativeEndian, uint16(0))
+	binary.Write(buf, nativeEndian, uint32(blockLen))
+	_, err := pw.w.Write(buf.Bytes())
+	return err
+}
+
+// epbFlags returns epb_flags option value describing the direction and reception type of the packet,
+// 0 if the direction is not known.
+func epbFlags(pt capture.PacketType) uint32 {
+	switch pt {
+	case capture.PacketTypeHost:
+		return epbFlagsInbound | epbFlagsUnicast
+	case capture.PacketTypeBroadcast:
+		return epbFlagsInbound | epbFlagsBroadcast
+	case capture.PacketTypeMulticast:
+		return epbFlagsInbound | epbFlagsMulticast
+	case capture.PacketTypeOtherHost:
+		return epbFlagsInbound | epbFlagsPromiscuous
+	case capture.PacketTypeOutgoing:
+		return epbFlagsOutbound
+	default:
+		return 0
+	}
+}
@@ -1,20 +1,31 @@
 package mshark
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mdlayher/packet"
 	"github.com/packetcap/go-pcap/filter"
-	"github.com/shadowy-pycoder/mshark/layers"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/layers"
 	"golang.org/x/net/bpf"
 )
 
-const unixEthPAll int = 0x03
+const (
+	unixEthPAll     int    = 0x03
+	etherHeaderLen  uint32 = 14
+	etherTypeOffset uint32 = 12
+)
 
 var colorMap = map[int]string{
 	0: "\033[37m",
@@ -27,16 +38,216 @@ var colorMap = map[int]string{
 var _ PacketWriter = &Writer{}
 
 type PacketWriter interface {
-	WritePacket(timestamp time.Time, data []byte) error
+	WritePacket(ci capture.Info, data []byte) error
+}
+
+// StatsWriter is implemented by PacketWriters that store capture statistics
+// of interfaces along with packets, e.g. pcapng Interface Statistics Blocks.
+type StatsWriter interface {
+	WriteStats(stats capture.Stats) error
+}
+
+type PacketReader interface {
+	ReadPacket() (ci capture.Info, data []byte, err error)
 }
 
 type Config struct {
-	Device      *net.Interface // The name of the network interface ("any" means listen on all interfaces).
-	Snaplen     int            // The maximum length of each packet snapshot.
-	Promisc     bool           // Promiscuous mode. This setting is ignored for "any" interface.
-	Timeout     time.Duration  // The maximum duration of the packet capture process.
-	PacketCount int            // The maximum number of packets to capture.
-	Expr        string         // BPF filter expression.
+	Devices       []*net.Interface // The network interfaces to capture from ("any" means listen on all interfaces).
+	Snaplen       int              // The maximum length of each packet snapshot.
+	Promisc       bool             // Promiscuous mode. This setting is ignored for "any" interface.
+	Timeout       time.Duration    // The maximum duration of the packet capture process.
+	PacketCount   int              // The maximum number of packets to capture.
+	Expr          string           // BPF filter expression.
+	File          string           // The name of the file packets are read from (offline capture only).
+	RingBuffer    bool             // Capture with TPACKET_V3 memory-mapped ring buffer instead of reading packets one by one.
+	RingSize      int              // The size of the ring buffer in bytes for each interface. Defaults to 32 MiB.
+	QueueSize     int              // The maximum number of packets waiting to be written by each PacketWriter. Defaults to 4096.
+	StatsOutput   io.Writer        // Where capture statistics are printed. Defaults to os.Stdout.
+	Direction     Direction        // The direction of packets to capture relative to the capturing host.
+	StatsInterval time.Duration    // How often interface statistics are written to outputs implementing StatsWriter. Defaults to 1 minute.
+	Comment       string           // Comment describing the capture, e.g. the reason it was taken.
+	Annotate      Annotator        // Attaches comments to captured packets, may be nil.
+}
+
+// Annotator returns a comment to attach to the packet, e.g. when the packet matches
+// some rule, or empty string to leave the packet without comment.
+//
+// Comments are written to pcapng files and shown in text output.
+// In live capture Annotator is called concurrently for packets from different interfaces.
+// The data must not be modified or retained after Annotator returns.
+type Annotator func(ci capture.Info, data []byte) string
+
+// Direction selects packets by their direction relative to the capturing host.
+type Direction int
+
+const (
+	DirectionInOut Direction = iota // Both received and sent packets.
+	DirectionIn                     // Received packets only.
+	DirectionOut                    // Sent packets only.
+)
+
+// ParseDirection returns the direction with the given name: in, out or inout.
+func ParseDirection(name string) (Direction, error) {
+	switch name {
+	case "", "inout":
+		return DirectionInOut, nil
+	case "in":
+		return DirectionIn, nil
+	case "out":
+		return DirectionOut, nil
+	default:
+		return DirectionInOut, fmt.Errorf("unsupported direction: %s", name)
+	}
+}
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionIn:
+		return "in"
+	case DirectionOut:
+		return "out"
+	default:
+		return "inout"
+	}
+}
+
+// Match reports whether packets of the given type are selected.
+//
+// Packets of unknown direction are selected only with DirectionInOut.
+func (d Direction) Match(pt capture.PacketType) bool {
+	switch d {
+	case DirectionIn:
+		return pt.Inbound()
+	case DirectionOut:
+		return pt.Outbound()
+	default:
+		return true
+	}
+}
+
+// TimestampFormat selects how timestamps of packets are displayed by Writer and SummaryWriter.
+type TimestampFormat int
+
+const (
+	TimestampSeconds        TimestampFormat = iota // Absolute date and time with one-second resolution.
+	TimestampMicro                                 // Absolute date and time with microseconds.
+	TimestampNano                                  // Absolute date and time with nanoseconds.
+	TimestampRelative                              // Seconds since the first displayed packet.
+	TimestampDelta                                 // Seconds since the previous captured packet.
+	TimestampDeltaDisplayed                        // Seconds since the previous displayed packet.
+	TimestampEpoch                                 // Seconds since the Unix epoch.
+)
+
+var timestampLayouts = map[TimestampFormat]string{
+	TimestampSeconds: "2006-01-02T15:04:05-0700",
+	TimestampMicro:   "2006-01-02T15:04:05.000000-0700",
+	TimestampNano:    "2006-01-02T15:04:05.000000000-0700",
+}
+
+// ParseTimestampFormat returns the timestamp format with the given name:
+// seconds, us, ns, relative, delta, delta_displayed or epoch.
+func ParseTimestampFormat(name string) (TimestampFormat, error) {
+	for f := TimestampSeconds; f <= TimestampEpoch; f++ {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return TimestampSeconds, fmt.Errorf("unsupported timestamp format: %s", name)
+}
+
+func (f TimestampFormat) String() string {
+	switch f {
+	case TimestampMicro:
+		return "us"
+	case TimestampNano:
+		return "ns"
+	case TimestampRelative:
+		return "relative"
+	case TimestampDelta:
+		return "delta"
+	case TimestampDeltaDisplayed:
+		return "delta_displayed"
+	case TimestampEpoch:
+		return "epoch"
+	default:
+		return "seconds"
+	}
+}
+
+// width returns the usual length of displayed timestamps.
+func (f TimestampFormat) width() int {
+	if layout, ok := timestampLayouts[f]; ok {
+		return len(layout)
+	}
+	if f == TimestampEpoch {
+		return len("1726565870.123456789")
+	}
+	return len("12345.123456")
+}
+
+// packetClock formats timestamps of packets displayed by a writer.
+type packetClock struct {
+	format TimestampFormat
+	first  time.Time // the timestamp of the first displayed packet
+	last   time.Time // the timestamp of the previous displayed packet
+}
+
+// timestamp returns the timestamp of the packet in the configured format
+// and records it as displayed.
+func (c *packetClock) timestamp(ci capture.Info) string {
+	if c.first.IsZero() {
+		c.first = ci.Timestamp
+	}
+	last := c.last
+	c.last = ci.Timestamp
+	if layout, ok := timestampLayouts[c.format]; ok {
+		return ci.Timestamp.Format(layout)
+	}
+	var since time.Time
+	switch c.format {
+	case TimestampEpoch:
+		return fmt.Sprintf("%d.%09d", ci.Timestamp.Unix(), ci.Timestamp.Nanosecond())
+	case TimestampRelative:
+		since = c.first
+	case TimestampDelta:
+		since = ci.Previous
+	case TimestampDeltaDisplayed:
+		since = last
+	}
+	if since.IsZero() {
+		since = ci.Timestamp
+	}
+	return fmt.Sprintf("%.6f", ci.Timestamp.Sub(since).Seconds())
+}
+
+// deviceNames returns comma separated names of the configured interfaces.
+func (c *Config) deviceNames() string {
+	names := make([]string, len(c.Devices))
+	for i, in := range c.Devices {
+		names[i] = in.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// statsOutput returns the writer for capture statistics.
+func (c *Config) statsOutput() io.Writer {
+	if c.StatsOutput == nil {
+		return os.Stdout
+	}
+	return c.StatsOutput
+}
+
+// statsInterval returns how often interface statistics are written.
+func (c *Config) statsInterval() time.Duration {
+	if c.StatsInterval <= 0 {
+		return defaultStatsInterval
+	}
+	return c.StatsInterval
+}
+
+// promisc reports whether promiscuous mode is enabled for the given interface.
+func (c *Config) promisc(in *net.Interface) bool {
+	return in.Name != "any" && c.Promisc
 }
 
 type Writer struct {
@@ -44,6 +255,9 @@ type Writer struct {
 	packets uint64
 	stdout  bool
 	verbose bool
+	hexDump bool
+	clock   packetClock
+	buf     bytes.Buffer
 }
 
 // NewWriter creates a new mshark Writer.
@@ -54,6 +268,17 @@ func NewWriter(w io.Writer, verbose bool) *Writer {
 		verbose: verbose}
 }
 
+// SetTimestampFormat sets how timestamps of packets are displayed. Defaults to TimestampSeconds.
+func (mw *Writer) SetTimestampFormat(f TimestampFormat) {
+	mw.clock.format = f
+}
+
+// SetHexDump enables hex and ASCII dumps of whole packets after their decoded layers.
+// If the writer is an instance of os.Stdout, bytes of layers are highlighted with their colors.
+func (mw *Writer) SetHexDump(enabled bool) {
+	mw.hexDump = enabled
+}
+
 // printPacket prints a layer packet to the writer. If the writer is an instance of os.Stdout,
 // the packet will be printed with color, based on the layerNum.
 func (mw *Writer) printPacket(layer layers.Layer, layerNum int) {
@@ -73,28 +298,102 @@ func (mw *Writer) printPacket(layer layers.Layer, layerNum int) {
 
 // WritePacket writes a packet to the writer, along with its timestamp.
 //
-// Timestamps are to be generated by the calling code.
-func (mw *Writer) WritePacket(timestamp time.Time, data []byte) error {
+// Timestamps are to be generated by the calling code. Decoding starts with
+// the layer corresponding to the link type of the packet.
+// Packets truncated by snaplen are marked as such and decoded as far as possible,
+// other packets that can not be decoded are not written.
+func (mw *Writer) WritePacket(ci capture.Info, data []byte) error {
+	decoded, truncated, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
 	mw.packets++
-	fmt.Fprintf(mw.w, "- Packet: %d Timestamp: %s\n", mw.packets, timestamp.Format("2006-01-02T15:04:05-0700"))
+	fmt.Fprintf(mw.w, "- Packet: %d Timestamp: %s", mw.packets, mw.clock.timestamp(ci))
+	if ci.Truncated(data) {
+		fmt.Fprintf(mw.w, " Length: %d (truncated to %d)", ci.Length, len(data))
+	}
+	if ci.PacketType != capture.PacketTypeUnknown {
+		fmt.Fprintf(mw.w, " Direction: %s", ci.PacketType)
+	}
+	if ci.Comment != "" {
+		fmt.Fprintf(mw.w, " Comment: %q", ci.Comment)
+	}
+	fmt.Fprintln(mw.w)
 	fmt.Fprintln(mw.w, "==================================================================")
-	next := layers.LayerMap["ETH"]
-	if err := next.Parse(data); err != nil {
+	for i, dl := range decoded {
+		mw.printPacket(dl.layer, i)
+	}
+	if truncated != "" {
+		fmt.Fprintf(mw.w, "[%s layer is truncated]\n", truncated)
+	}
+	if mw.hexDump {
+		mw.buf.Reset()
+		writeHexDump(&mw.buf, data, layerRanges(decoded), mw.stdout)
+		_, err = mw.w.Write(mw.buf.Bytes())
 		return err
 	}
-	var layerNum int
-	mw.printPacket(next, layerNum)
-	for {
-		name, data := next.NextLayer()
-		if name == "" || data == nil || len(data) == 0 {
-			return nil
+	return nil
+}
+
+// decodedLayer is a layer decoded from packet data.
+type decodedLayer struct {
+	name  string // The name of the layer in layers.LayerMap.
+	layer layers.Layer
+	pos   int // The offset of the layer in the packet data.
+	size  int // The size of the layer header, or of the rest of the data for the last layer.
+}
+
+// decodeLayers decodes the packet starting with the layer corresponding to its link type.
+//
+// Every layer is decoded with its own instance, so that packets can be decoded concurrently
+// with other writers. It also returns the name of the layer that did not fit into the data
+// if decoding stopped early because the packet is truncated.
+func decodeLayers(ci capture.Info, data []byte) ([]decodedLayer, string, error) {
+	name, payload, err := layers.FirstLayer(ci.LinkType, data)
+	if err != nil {
+		return nil, "", err
+	}
+	var decoded []decodedLayer
+	for len(payload) > 0 {
+		layer := layers.NewLayer(name)
+		if err := layer.Parse(payload); err != nil {
+			if ci.Truncated(data) {
+				return decoded, name, nil
+			}
+			return nil, "", err
 		}
-		next = layers.LayerMap[name]
-		if err := next.Parse(data); err != nil {
-			return err
+		dl := decodedLayer{name: name, layer: layer, pos: len(data) - len(payload), size: len(payload)}
+		name, payload = layer.NextLayer()
+		if name != "" {
+			dl.size -= len(payload)
+		}
+		decoded = append(decoded, dl)
+		if name == "" {
+			break
+		}
+	}
+	return decoded, "", nil
+}
+
+// writeFooter writes the number of packets written by the writer.
+func (mw *Writer) writeFooter() {
+	fmt.Fprintf(mw.w, "- Packets Captured: %d\n", mw.packets)
+}
+
+// footerWriter is implemented by PacketWriters that finish their output with a footer.
+type footerWriter interface {
+	writeFooter()
+}
+
+// writeFooters writes footers of PacketWriters, including the current file of Rotators.
+func writeFooters(pw []PacketWriter) {
+	for _, w := range pw {
+		if r, ok := w.(*Rotator); ok {
+			w = r.pw
+		}
+		if w, ok := w.(footerWriter); ok {
+			w.writeFooter()
 		}
-		layerNum++
-		mw.printPacket(next, layerNum)
 	}
 }
 
@@ -105,29 +404,53 @@ func (mw *Writer) WritePacket(timestamp time.Time, data []byte) error {
 //
 // The header is written in the following format:
 //
-//   - Interface: eth0
+//   - Interface: eth0, eth1
 //   - Snapshot Length: 65535
 //   - Promiscuous Mode: true
 //   - Timeout: 5s
 //   - Number of Packets: 0
 //   - BPF Filter: "ip proto tcp"
+//   - Direction: inout
 //   - Verbose: true
+//
+// For offline capture, the interface, snapshot length, promiscuous mode and timeout
+// are replaced with the name of the file:
+//
+//   - File: capture.pcap
 func (mw *Writer) WriteHeader(c *Config) error {
+	if c.File != "" {
+		_, err := fmt.Fprintf(mw.w, `- File: %s
+- Number of Packets: %d
+- BPF Filter: %q
+- Direction: %s
+- Verbose: %v
+
+`,
+			c.File,
+			c.PacketCount,
+			c.Expr,
+			c.Direction,
+			mw.verbose,
+		)
+		return err
+	}
 	_, err := fmt.Fprintf(mw.w, `- Interface: %s
 - Snapshot Length: %d
 - Promiscuous Mode: %v
 - Timeout: %s
 - Number of Packets: %d
 - BPF Filter: %q
+- Direction: %s
 - Verbose: %v
 
 `,
-		c.Device.Name,
+		c.deviceNames(),
 		c.Snaplen,
-		c.Device.Name != "any" && c.Promisc,
+		slices.ContainsFunc(c.Devices, c.promisc),
 		c.Timeout,
 		c.PacketCount,
 		c.Expr,
+		c.Direction,
 		mw.verbose,
 	)
 	return err
@@ -146,78 +469,442 @@ func InterfaceByName(name string) (*net.Interface, error) {
 		if err != nil {
 			return nil, fmt.Errorf("unknown interface %s: %v", name, err)
 		}
-		ok := true &&
-			// Look for an Ethernet interface.
-			len(in.HardwareAddr) == 6 &&
-			// Look for up, multicast, broadcast.
-			in.Flags&(net.FlagUp|net.FlagMulticast|net.FlagBroadcast) != 0
-		if !ok {
+		if in.Flags&net.FlagUp == 0 {
 			return nil, fmt.Errorf("interface %s is not up", name)
 		}
 	}
 	return in, nil
 }
 
+// LinkType returns the link-layer header type of packets captured on the interface.
+//
+// Packets captured on "any" interface have Linux cooked capture v2 (SLL2) headers,
+// packets captured on tunnels have no link-layer header (raw IP),
+// other interfaces are Ethernet or loopback.
+func LinkType(in *net.Interface) (int, error) {
+	if in.Name == "any" {
+		return capture.LinkTypeLinuxSLL2, nil
+	}
+	return interfaceLinkType(in)
+}
+
+// compileFilter compiles BPF filter expression into instructions.
+func compileFilter(expr string) ([]bpf.Instruction, error) {
+	e := filter.NewExpression(expr)
+	f := e.Compile()
+	instructions, err := f.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile filter into instructions: %v", err)
+	}
+	return instructions, nil
+}
+
+// rawFilter adapts filter instructions compiled for Ethernet to packets without Ethernet header.
+//
+// Loads from IP header and above are made relative to the network header and EtherType
+// is taken from the protocol of the packet, so that the same filter expressions work on tunnels
+// and on "any" interface, where packets come with link-layer headers of their devices.
+// Filters on Ethernet addresses are not supported.
+func rawFilter(instructions []bpf.Instruction) ([]bpf.Instruction, error) {
+	errLinkLayer := errors.New("filters on link-layer header are only supported on Ethernet interfaces")
+	raw := make([]bpf.Instruction, len(instructions))
+	for i, ins := range instructions {
+		switch ins := ins.(type) {
+		case bpf.LoadAbsolute:
+			if ins.Off == etherTypeOffset && ins.Size == 2 {
+				raw[i] = bpf.LoadExtension{Num: bpf.ExtProto}
+				continue
+			}
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off = netOffset(ins.Off)
+			raw[i] = ins
+		case bpf.LoadIndirect:
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off = netOffset(ins.Off)
+			raw[i] = ins
+		case bpf.LoadMemShift:
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off = netOffset(ins.Off)
+			raw[i] = ins
+		default:
+			raw[i] = ins
+		}
+	}
+	return raw, nil
+}
+
+// netOffset converts the offset in Ethernet frame to the offset relative to the network header
+// (SKF_NET_OFF in linux/filter.h).
+func netOffset(off uint32) uint32 {
+	const skfNetOff = -0x100000
+	return uint32(skfNetOff + int32(off-etherHeaderLen))
+}
+
+// offlineFilter adapts filter instructions compiled for Ethernet to packets of the given link type
+// read from files.
+//
+// Filters are run in bpf.VM, which has no access to kernel extensions, so loads are moved
+// to the offsets of the network header and EtherType in the link-layer header of the link type.
+// Packets without EtherType get it from the link type or from the version of the IP header.
+// Filters on Ethernet addresses are not supported.
+func offlineFilter(instructions []bpf.Instruction, linkType int) ([]bpf.Instruction, error) {
+	var (
+		netOff, typeOff uint32
+		etherType       []bpf.Instruction // replaces loads of EtherType missing from the link-layer header
+	)
+	switch linkType {
+	case capture.LinkTypeEthernet:
+		return instructions, nil
+	case capture.LinkTypeLinuxSLL:
+		netOff, typeOff = 16, 14
+	case capture.LinkTypeLinuxSLL2:
+		netOff, typeOff = 20, 0
+	case capture.LinkTypeNull:
+		// address family is in the byte order of the capturing host, so EtherType is taken from IP version
+		netOff = 4
+		etherType = ipEtherType(netOff)
+	case capture.LinkTypeRaw:
+		etherType = ipEtherType(netOff)
+	case capture.LinkTypeIPv4:
+		etherType = []bpf.Instruction{bpf.LoadConstant{Dst: bpf.RegA, Val: 0x0800}}
+	case capture.LinkTypeIPv6:
+		etherType = []bpf.Instruction{bpf.LoadConstant{Dst: bpf.RegA, Val: 0x86dd}}
+	default:
+		return nil, fmt.Errorf("filters are not supported for link type %d", linkType)
+	}
+	errLinkLayer := fmt.Errorf("filters on link-layer header are not supported for link type %d", linkType)
+	expanded := make([][]bpf.Instruction, len(instructions))
+	for i, ins := range instructions {
+		switch ins := ins.(type) {
+		case bpf.LoadAbsolute:
+			if ins.Off == etherTypeOffset && ins.Size == 2 {
+				if etherType != nil {
+					expanded[i] = etherType
+					continue
+				}
+				ins.Off = typeOff
+				expanded[i] = []bpf.Instruction{ins}
+				continue
+			}
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off += netOff - etherHeaderLen
+			expanded[i] = []bpf.Instruction{ins}
+		case bpf.LoadIndirect:
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off += netOff - etherHeaderLen
+			expanded[i] = []bpf.Instruction{ins}
+		case bpf.LoadMemShift:
+			if ins.Off < etherHeaderLen {
+				return nil, errLinkLayer
+			}
+			ins.Off += netOff - etherHeaderLen
+			expanded[i] = []bpf.Instruction{ins}
+		default:
+			expanded[i] = []bpf.Instruction{ins}
+		}
+	}
+	return relocateJumps(expanded)
+}
+
+// ipEtherType returns instructions loading EtherType of the IP packet at the offset
+// based on its version, or zero for other packets.
+func ipEtherType(off uint32) []bpf.Instruction {
+	return []bpf.Instruction{
+		bpf.LoadAbsolute{Off: off, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: 2},
+		bpf.LoadConstant{Dst: bpf.RegA, Val: 0x0800},
+		bpf.Jump{Skip: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 2},
+		bpf.LoadConstant{Dst: bpf.RegA, Val: 0x86dd},
+		bpf.Jump{Skip: 1},
+		bpf.LoadConstant{Dst: bpf.RegA, Val: 0},
+	}
+}
+
+// relocateJumps joins instructions that replaced the original instructions of a filter,
+// so that jumps of the original instructions keep pointing at the same instructions.
+func relocateJumps(expanded [][]bpf.Instruction) ([]bpf.Instruction, error) {
+	// positions of the original instructions in the joined filter
+	pos := make([]int, len(expanded)+1)
+	for i, e := range expanded {
+		pos[i+1] = pos[i] + len(e)
+	}
+	skip := func(i int, n uint32) (uint32, error) {
+		target := i + 1 + int(n)
+		if target >= len(pos) {
+			return 0, fmt.Errorf("filter jumps out of bounds")
+		}
+		return uint32(pos[target] - pos[i+1]), nil
+	}
+	skip8 := func(i int, n uint8) (uint8, error) {
+		s, err := skip(i, uint32(n))
+		if err != nil {
+			return 0, err
+		}
+		if s > math.MaxUint8 {
+			return 0, fmt.Errorf("filter is too long for conditional jumps")
+		}
+		return uint8(s), nil
+	}
+	var (
+		relocated []bpf.Instruction
+		err       error
+	)
+	for i, e := range expanded {
+		if len(e) != 1 {
+			relocated = append(relocated, e...)
+			continue
+		}
+		switch ins := e[0].(type) {
+		case bpf.Jump:
+			if ins.Skip, err = skip(i, ins.Skip); err != nil {
+				return nil, err
+			}
+			relocated = append(relocated, ins)
+		case bpf.JumpIf:
+			if ins.SkipTrue, err = skip8(i, ins.SkipTrue); err != nil {
+				return nil, err
+			}
+			if ins.SkipFalse, err = skip8(i, ins.SkipFalse); err != nil {
+				return nil, err
+			}
+			relocated = append(relocated, ins)
+		case bpf.JumpIfX:
+			if ins.SkipTrue, err = skip8(i, ins.SkipTrue); err != nil {
+				return nil, err
+			}
+			if ins.SkipFalse, err = skip8(i, ins.SkipFalse); err != nil {
+				return nil, err
+			}
+			relocated = append(relocated, ins)
+		default:
+			relocated = append(relocated, ins)
+		}
+	}
+	return relocated, nil
+}
+
+// offlineVM runs a filter on packets read from files, adapting it to the link type of each packet.
+type offlineVM struct {
+	instructions []bpf.Instruction
+	vms          map[int]*bpf.VM
+}
+
+// match reports whether the packet is selected by the filter.
+func (o *offlineVM) match(ci capture.Info, data []byte) (bool, error) {
+	vm, ok := o.vms[ci.LinkType]
+	if !ok {
+		instructions, err := offlineFilter(o.instructions, ci.LinkType)
+		if err != nil {
+			return false, err
+		}
+		vm, err = bpf.NewVM(instructions)
+		if err != nil {
+			return false, fmt.Errorf("failed to load filter: %v", err)
+		}
+		o.vms[ci.LinkType] = vm
+	}
+	n, err := vm.Run(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to run filter: %v", err)
+	}
+	return n > 0, nil
+}
+
+// assembleFilter assembles filter instructions for the interface with the given link type.
+//
+// The kernel runs filters on packets before SLL2 headers are added, so filters on "any"
+// interface are adapted the same way as on interfaces without link-layer header.
+func assembleFilter(instructions []bpf.Instruction, linkType int) ([]bpf.RawInstruction, error) {
+	if len(instructions) == 0 {
+		return nil, nil
+	}
+	if linkType == capture.LinkTypeRaw || linkType == capture.LinkTypeLinuxSLL2 {
+		var err error
+		instructions, err = rawFilter(instructions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	raw, err := bpf.Assemble(instructions)
+	if err != nil {
+		return nil, fmt.Errorf("bpf assembly failed: %v", err)
+	}
+	return raw, nil
+}
+
 // OpenLive opens a live capture based on the given configuration and writes
 // all captured packets to the given PacketWriters.
+//
+// When several interfaces are configured, a connection is opened on each of them
+// and packets are merged in the order they are read. The interface ID of a packet
+// is the index of its interface in the configuration.
+//
+// Each PacketWriter runs in its own goroutine with a queue of conf.QueueSize packets,
+// so that slow writers do not stall reading. Packets that do not fit into a full queue
+// are dropped and reported as userspace drops.
 func OpenLive(conf *Config, pw ...PacketWriter) error {
+	return OpenLiveContext(context.Background(), conf, pw...)
+}
 
-	packetcfg := packet.Config{}
+// OpenLiveContext is like OpenLive but stops the capture when the context is done.
+//
+// Cancellation is treated as a normal end of capture: the packet being written
+// is completed, statistics are printed and nil is returned.
+func OpenLiveContext(ctx context.Context, conf *Config, pw ...PacketWriter) error {
+	if len(conf.Devices) == 0 {
+		return fmt.Errorf("no interfaces to capture from")
+	}
 
 	// setting up filter
+	var instructions []bpf.Instruction
 	if conf.Expr != "" {
-		e := filter.NewExpression(conf.Expr)
-		f := e.Compile()
-		instructions, err := f.Compile()
+		var err error
+		instructions, err = compileFilter(conf.Expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	conns := make([]source, 0, len(conf.Devices))
+	defer func() {
+		for _, c := range conns {
+			c.close()
+		}
+	}()
+	for _, in := range conf.Devices {
+		linkType, err := LinkType(in)
 		if err != nil {
-			return fmt.Errorf("failed to compile filter into instructions: %v", err)
+			return err
+		}
+		filter, err := assembleFilter(instructions, linkType)
+		if err != nil {
+			return fmt.Errorf("unable to set filter on %s: %v", in.Name, err)
+		}
+		var c source
+		if conf.RingBuffer {
+			c, err = listenRing(conf, in, linkType, filter)
+		} else {
+			c, err = listen(conf, in, linkType, &packet.Config{Filter: filter})
 		}
-		raw, err := bpf.Assemble(instructions)
 		if err != nil {
-			return fmt.Errorf("bpf assembly failed: %v", err)
+			return err
 		}
-		packetcfg.Filter = raw
+		conns = append(conns, c)
 	}
 
-	// opening connection
-	c, err := packet.Listen(conf.Device, packet.Raw, unixEthPAll, &packetcfg)
-	if err != nil {
-		if errors.Is(err, os.ErrPermission) {
-			return fmt.Errorf("permission denied (try setting CAP_NET_RAW capability): %v", err)
-		}
-		return fmt.Errorf("failed to listen: %v", err)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pl := newPipeline(conf, cancel, pw...)
+
+	var wwg sync.WaitGroup
+	for _, q := range pl.queues {
+		wwg.Add(1)
+		go func() {
+			defer wwg.Done()
+			pl.write(q)
+		}()
 	}
+	var rwg sync.WaitGroup
+	for i, c := range conns {
+		rwg.Add(1)
+		go func() {
+			defer rwg.Done()
+			pl.read(ctx, c, i)
+		}()
+	}
+	readersDone := make(chan struct{})
+	go func() {
+		rwg.Wait()
+		close(readersDone)
+	}()
 
-	// setting promisc mode
-	if conf.Device.Name != "any" {
-		if err := c.SetPromiscuous(conf.Promisc); err != nil {
-			return fmt.Errorf("unable to set promiscuous mode: %v", err)
+	// waiting for the end of capture, writing statistics periodically
+	sc := newStatsCollector(len(conns))
+	ticker := time.NewTicker(conf.statsInterval())
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-readersDone:
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			pl.writeStats(sc.collect(conns), false)
 		}
 	}
+	cancel()
 
-	// timeout
-	if conf.Timeout > 0 {
-		if err := c.SetDeadline(time.Now().Add(conf.Timeout)); err != nil {
-			return fmt.Errorf("unable to set timeout: %v", err)
-		}
+	// fetching stats before closing connections, since readers blocked
+	// on a connection are only released by closing it
+	stats := sc.collect(conns)
+	for _, c := range conns {
+		c.close()
 	}
+	conns = nil
+	rwg.Wait()
 
-	defer func() {
-		stats, err := c.Stats()
+	// writing the rest of queued packets followed by the final statistics
+	pl.writeStats(stats, true)
+	for _, q := range pl.queues {
+		close(q.packets)
+	}
+	wwg.Wait()
+
+	// printing statistics once writers are done, so that they do not interleave with packets
+	for _, st := range stats {
+		total := sc.totals[st.InterfaceID]
+		fmt.Fprintf(conf.statsOutput(), "- Interface: %s Packets: %d, Drops: %d, Freeze Queue Count: %d\n",
+			conf.Devices[st.InterfaceID].Name, total.Packets, total.Drops, total.FreezeQueueCount)
+	}
+	fmt.Fprintf(conf.statsOutput(), "- Userspace Drops: %d\n", pl.drops.Load())
+	writeFooters(pw)
+	return pl.err
+}
+
+// source is a capture backend reading packets from a single interface.
+type source interface {
+	// next returns the next packet and its capture metadata.
+	// The data is valid until the next call.
+	next() (capture.Info, []byte, error)
+	stats() (*packet.Stats, error)
+	close() error
+}
+
+// OpenOffline reads packets from the given PacketReader until io.EOF and writes
+// them to the given PacketWriters.
+//
+// BPF filter expression, direction and the maximum number of packets are taken from
+// the configuration, other settings are ignored. Since there is no kernel
+// to filter packets, the filter is run in userspace.
+func OpenOffline(conf *Config, pr PacketReader, pw ...PacketWriter) error {
+	return OpenOfflineContext(context.Background(), conf, pr, pw...)
+}
+
+// OpenOfflineContext is like OpenOffline but stops reading when the context is done.
+func OpenOfflineContext(ctx context.Context, conf *Config, pr PacketReader, pw ...PacketWriter) error {
+	// headers are already written, so footers are written on errors as well
+	defer writeFooters(pw)
+	var vm *offlineVM
+	if conf.Expr != "" {
+		instructions, err := compileFilter(conf.Expr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch stats: %v", err)
-		} else {
-			fmt.Printf("- Packets: %d, Drops: %d, Freeze Queue Count: %d\n",
-				stats.Packets, stats.Drops, stats.FreezeQueueCount)
-			for _, w := range pw {
-				if w, ok := w.(*Writer); ok {
-					fmt.Fprintf(w.w, "- Packets Captured: %d\n", w.packets)
-				}
-			}
+			return err
 		}
-		// close Conn
-		c.Close()
-	}()
+		vm = &offlineVM{instructions: instructions, vms: make(map[int]*bpf.VM)}
+	}
 
 	// number of packets
 	count := conf.PacketCount
@@ -226,18 +913,36 @@ func OpenLive(conf *Config, pw ...PacketWriter) error {
 	}
 	infinity := count == 0
 
-	b := make([]byte, conf.Snaplen)
-
-	for i := 0; infinity || i < count; i++ {
-		n, _, err := c.ReadFrom(b)
+	var previous time.Time
+	for i := 0; (infinity || i < count) && ctx.Err() == nil; {
+		ci, data, err := pr.ReadPacket()
 		if err != nil {
-			if errors.Is(err, os.ErrDeadlineExceeded) {
-				return nil
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read packet: %v", err)
+		}
+		ci.Previous, previous = previous, ci.Timestamp
+		if vm != nil {
+			ok, err := vm.match(ci, data)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if !conf.Direction.Match(ci.PacketType) {
+			continue
+		}
+		if conf.Annotate != nil {
+			if comment := conf.Annotate(ci, data); comment != "" {
+				ci.Comment = comment
 			}
-			return fmt.Errorf("failed to read Ethernet frame: %v", err)
 		}
+		i++
 		for _, w := range pw {
-			if err := w.WritePacket(time.Now().UTC(), b[:n]); err != nil {
+			if err := w.WritePacket(ci, data); err != nil {
 				return err
 			}
 		}
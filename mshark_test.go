@@ -1,8 +1,22 @@
 package mshark
 
 import (
+	"bytes"
+	"context"
 	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mdlayher/packet"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/mpcap"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
 )
 
 func BenchmarkOpenLive(b *testing.B) {
@@ -12,7 +26,7 @@ func BenchmarkOpenLive(b *testing.B) {
 		b.Fatal(err)
 	}
 	conf := Config{
-		Device:      in,
+		Devices:     []*net.Interface{in},
 		Snaplen:     1600,
 		PacketCount: b.N,
 	}
@@ -21,3 +35,519 @@ func BenchmarkOpenLive(b *testing.B) {
 		b.Fatal(err)
 	}
 }
+
+type testSource struct {
+	packets [][]byte
+}
+
+func (s *testSource) next() (capture.Info, []byte, error) {
+	if len(s.packets) == 0 {
+		return capture.Info{}, nil, os.ErrDeadlineExceeded
+	}
+	p := s.packets[0]
+	s.packets = s.packets[1:]
+	ci := capture.Info{Timestamp: time.Unix(int64(p[0]), 0).UTC()}
+	if len(p) > 1 {
+		ci.PacketType = capture.PacketType(p[1])
+	}
+	return ci, p, nil
+}
+
+func (s *testSource) stats() (*packet.Stats, error) { return &packet.Stats{}, nil }
+
+func (s *testSource) close() error { return nil }
+
+type testWriter struct {
+	infos   []capture.Info
+	packets [][]byte
+}
+
+func (w *testWriter) WritePacket(ci capture.Info, data []byte) error {
+	w.infos = append(w.infos, ci)
+	w.packets = append(w.packets, slices.Clone(data))
+	return nil
+}
+
+func TestPipelineDrops(t *testing.T) {
+	src := &testSource{}
+	for i := range 10 {
+		src.packets = append(src.packets, []byte{byte(i)})
+	}
+	w1, w2 := &testWriter{}, &testWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pl := newPipeline(&Config{Snaplen: 16, QueueSize: 4}, cancel, w1, w2)
+	// writers are not running, so reading must not block on full queues
+	pl.read(ctx, src, 0)
+	require.Equal(t, uint64(6), pl.drops.Load())
+	for _, q := range pl.queues {
+		close(q.packets)
+		pl.write(q)
+	}
+	require.NoError(t, pl.err)
+	expected := [][]byte{{0}, {1}, {2}, {3}}
+	require.Equal(t, expected, w1.packets)
+	require.Equal(t, expected, w2.packets)
+}
+
+func TestPipelinePacketCount(t *testing.T) {
+	src := &testSource{packets: [][]byte{{1}, {2}, {3}, {4}}}
+	w := &testWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pl := newPipeline(&Config{Snaplen: 16, PacketCount: 2}, cancel, w)
+	pl.read(ctx, src, 1)
+	require.Error(t, ctx.Err())
+	close(pl.queues[0].packets)
+	pl.write(pl.queues[0])
+	require.Equal(t, [][]byte{{1}, {2}}, w.packets)
+	// timestamps are taken from the source
+	require.Equal(t, []capture.Info{
+		{Timestamp: time.Unix(1, 0).UTC(), InterfaceID: 1},
+		{Timestamp: time.Unix(2, 0).UTC(), InterfaceID: 1, Previous: time.Unix(1, 0).UTC()},
+	}, w.infos)
+}
+
+func TestPipelinePrevious(t *testing.T) {
+	// timestamps of the sources overlap, so packets are read out of order whichever source goes first
+	src1, src2 := &testSource{}, &testSource{}
+	for i := range 50 {
+		src1.packets = append(src1.packets, []byte{byte(2*i + 1)})
+		src2.packets = append(src2.packets, []byte{byte(2*i + 2)})
+	}
+	w := &testWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pl := newPipeline(&Config{Snaplen: 16}, cancel, w)
+	var wg sync.WaitGroup
+	for i, src := range []*testSource{src1, src2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pl.read(ctx, src, i)
+		}()
+	}
+	wg.Wait()
+	close(pl.queues[0].packets)
+	pl.write(pl.queues[0])
+	require.Len(t, w.infos, 100)
+	// the previous timestamp is the latest one queued before the packet, but never after its own
+	var latest time.Time
+	for _, ci := range w.infos {
+		expected := latest
+		if expected.After(ci.Timestamp) {
+			expected = ci.Timestamp
+		}
+		require.Equal(t, expected, ci.Previous)
+		if ci.Timestamp.After(latest) {
+			latest = ci.Timestamp
+		}
+	}
+}
+
+type testStatsWriter struct {
+	testWriter
+	stats []capture.Stats
+}
+
+func (w *testStatsWriter) WriteStats(stats capture.Stats) error {
+	// packets written so far are recorded in the received count to check the order
+	stats.Received = uint64(len(w.packets))
+	w.stats = append(w.stats, stats)
+	return nil
+}
+
+func TestPipelineStats(t *testing.T) {
+	src := &testSource{packets: [][]byte{{1}, {2}}}
+	w, sw := &testWriter{}, &testStatsWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pl := newPipeline(&Config{Snaplen: 16}, cancel, w, sw)
+	pl.read(ctx, src, 0)
+	pl.writeStats([]capture.Stats{{InterfaceID: 0}, {InterfaceID: 1}}, true)
+	for _, q := range pl.queues {
+		close(q.packets)
+		pl.write(q)
+	}
+	require.NoError(t, pl.err)
+	require.Len(t, w.packets, 2)
+	require.Equal(t, []capture.Stats{{InterfaceID: 0, Received: 2}, {InterfaceID: 1, Received: 2}}, sw.stats)
+}
+
+func TestStatsCollector(t *testing.T) {
+	sc := newStatsCollector(1)
+	src := &testStatsSource{}
+	for range 2 {
+		sc.collect([]source{src})
+	}
+	stats := sc.collect([]source{src})
+	require.Equal(t, uint64(30), stats[0].Received)
+	require.Equal(t, uint64(3), stats[0].Dropped)
+	require.Equal(t, sc.start, stats[0].StartTime)
+}
+
+// testStatsSource reports 10 packets and 1 drop since the previous call like the kernel does.
+type testStatsSource struct {
+	testSource
+}
+
+func (s *testStatsSource) stats() (*packet.Stats, error) {
+	return &packet.Stats{Packets: 10, Drops: 1}, nil
+}
+
+func TestPipelineDirection(t *testing.T) {
+	packets := [][]byte{
+		{1, byte(capture.PacketTypeHost)},
+		{2, byte(capture.PacketTypeOutgoing)},
+		{3, byte(capture.PacketTypeBroadcast)},
+		{4, byte(capture.PacketTypeUnknown)},
+	}
+	for _, tt := range []struct {
+		direction string
+		expected  [][]byte
+	}{
+		{"inout", packets},
+		{"in", [][]byte{packets[0], packets[2]}},
+		{"out", [][]byte{packets[1]}},
+	} {
+		d, err := ParseDirection(tt.direction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, tt.direction, d.String())
+		src := &testSource{packets: slices.Clone(packets)}
+		w := &testWriter{}
+		ctx, cancel := context.WithCancel(context.Background())
+		pl := newPipeline(&Config{Snaplen: 16, Direction: d}, cancel, w)
+		pl.read(ctx, src, 0)
+		cancel()
+		close(pl.queues[0].packets)
+		pl.write(pl.queues[0])
+		require.Equal(t, tt.expected, w.packets)
+	}
+	_, err := ParseDirection("both")
+	require.Error(t, err)
+}
+
+func TestPipelineAnnotate(t *testing.T) {
+	src := &testSource{packets: [][]byte{{1}, {2}, {3}}}
+	w := &testWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	annotate := func(ci capture.Info, data []byte) string {
+		if data[0] == 2 {
+			return "incident started"
+		}
+		return ""
+	}
+	pl := newPipeline(&Config{Snaplen: 16, Annotate: annotate}, cancel, w)
+	pl.read(ctx, src, 0)
+	cancel()
+	close(pl.queues[0].packets)
+	pl.write(pl.queues[0])
+	require.Len(t, w.infos, 3)
+	require.Equal(t, "", w.infos[0].Comment)
+	require.Equal(t, "incident started", w.infos[1].Comment)
+	require.Equal(t, "", w.infos[2].Comment)
+}
+
+func TestWriterTruncated(t *testing.T) {
+	data, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IPv4 header does not fit into the captured data
+	data = append(data, 0x45, 0x00, 0x05, 0xdc)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, false)
+	if err := w.WritePacket(capture.Info{Length: 1514, LinkType: capture.LinkTypeEthernet}, data); err != nil {
+		t.Fatal(err)
+	}
+	require.Contains(t, buf.String(), "Length: 1514 (truncated to 18)")
+	require.Contains(t, buf.String(), "[IPv4 layer is truncated]")
+	require.Error(t, w.WritePacket(capture.Info{LinkType: capture.LinkTypeEthernet}, data))
+}
+
+func TestWriterConcurrent(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{append(slices.Clone(eth), ipv4...), eth}
+	// writers run in their own goroutines in the pipeline, so they must not share decoded layers
+	var (
+		wg   sync.WaitGroup
+		bufs [2]bytes.Buffer
+	)
+	for i := range bufs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := NewWriter(&bufs[i], true)
+			for j := range 100 {
+				if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeEthernet}, packets[(i+j)%2]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var expected bytes.Buffer
+	w := NewWriter(&expected, true)
+	for j := range 100 {
+		if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeEthernet}, packets[j%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	require.Equal(t, expected.String(), bufs[0].String())
+}
+
+func TestWriterLinkTypes(t *testing.T) {
+	sll2, err := os.ReadFile("layers/testdata/sll2.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, false)
+	if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeLinuxSLL2}, append(sll2, ipv4...)); err != nil {
+		t.Fatal(err)
+	}
+	require.Contains(t, buf.String(), "Linux Cooked Capture v2: Interface Index: 2 Sent by us")
+	require.Contains(t, buf.String(), "IPv4 Packet")
+	require.Error(t, w.WritePacket(capture.Info{LinkType: 12345}, ipv4))
+}
+
+func TestWriterTimestampFormat(t *testing.T) {
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 9, 17, 9, 37, 50, 123456789, time.UTC)
+	// the second displayed packet follows a packet that was captured but not displayed
+	infos := []capture.Info{
+		{Timestamp: start, LinkType: capture.LinkTypeRaw},
+		{Timestamp: start.Add(1500 * time.Millisecond), Previous: start.Add(time.Second), LinkType: capture.LinkTypeRaw},
+	}
+	for _, tc := range []struct {
+		name     string
+		expected [2]string
+	}{
+		{"seconds", [2]string{"2024-09-17T09:37:50+0000", "2024-09-17T09:37:51+0000"}},
+		{"us", [2]string{"2024-09-17T09:37:50.123456+0000", "2024-09-17T09:37:51.623456+0000"}},
+		{"ns", [2]string{"2024-09-17T09:37:50.123456789+0000", "2024-09-17T09:37:51.623456789+0000"}},
+		{"relative", [2]string{"0.000000", "1.500000"}},
+		{"delta", [2]string{"0.000000", "0.500000"}},
+		{"delta_displayed", [2]string{"0.000000", "1.500000"}},
+		{"epoch", [2]string{"1726565870.123456789", "1726565871.623456789"}},
+	} {
+		f, err := ParseTimestampFormat(tc.name)
+		require.NoError(t, err)
+		require.Equal(t, tc.name, f.String())
+		var buf bytes.Buffer
+		w := NewWriter(&buf, false)
+		w.SetTimestampFormat(f)
+		for i, ci := range infos {
+			buf.Reset()
+			if err := w.WritePacket(ci, ipv4); err != nil {
+				t.Fatal(err)
+			}
+			require.Contains(t, buf.String(), " Timestamp: "+tc.expected[i]+"\n", tc.name)
+		}
+	}
+	_, err = ParseTimestampFormat("foo")
+	require.Error(t, err)
+}
+
+func TestWriterRawIP(t *testing.T) {
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv6, err := os.ReadFile("layers/testdata/ipv6.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, false)
+	if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeRaw}, ipv6); err != nil {
+		t.Fatal(err)
+	}
+	require.True(t, strings.HasPrefix(strings.Split(buf.String(), "\n")[2], "IPv6 Packet"))
+	buf.Reset()
+	// BSD loopback header with AF_INET in little-endian byte order
+	if err := w.WritePacket(capture.Info{LinkType: capture.LinkTypeNull}, append([]byte{2, 0, 0, 0}, ipv4...)); err != nil {
+		t.Fatal(err)
+	}
+	require.True(t, strings.HasPrefix(strings.Split(buf.String(), "\n")[2], "IPv4 Packet"))
+	buf.Reset()
+	require.Error(t, w.WritePacket(capture.Info{LinkType: capture.LinkTypeRaw}, []byte{0x50}))
+	require.Zero(t, buf.Len())
+}
+
+func TestRawFilter(t *testing.T) {
+	instructions, err := compileFilter("tcp port 80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := rawFilter(instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, bpf.LoadExtension{Num: bpf.ExtProto}, raw[0])
+	// IPv6 next header is the 6th byte of the network header
+	require.Equal(t, bpf.LoadAbsolute{Off: 0xfff00006, Size: 1}, raw[2])
+	require.Contains(t, raw, bpf.LoadMemShift{Off: 0xfff00000})
+
+	instructions, err = compileFilter("ether host 02:fc:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rawFilter(instructions)
+	require.Error(t, err)
+}
+
+func TestAssembleFilter(t *testing.T) {
+	instructions, err := compileFilter("udp port 53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth, err := assembleFilter(instructions, capture.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := bpf.Assemble(instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, expected, eth)
+	raw, err := rawFilter(instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err = bpf.Assemble(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, linkType := range []int{capture.LinkTypeRaw, capture.LinkTypeLinuxSLL2} {
+		filter, err := assembleFilter(instructions, linkType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		require.Equal(t, expected, filter)
+	}
+
+	instructions, err = compileFilter("ether host 02:fc:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = assembleFilter(instructions, capture.LinkTypeLinuxSLL2)
+	require.Error(t, err)
+}
+
+type testReader struct {
+	infos   []capture.Info
+	packets [][]byte
+}
+
+func (r *testReader) ReadPacket() (capture.Info, []byte, error) {
+	if len(r.packets) == 0 {
+		return capture.Info{}, nil, io.EOF
+	}
+	ci, data := r.infos[0], r.packets[0]
+	r.infos, r.packets = r.infos[1:], r.packets[1:]
+	return ci, data, nil
+}
+
+func TestOpenOfflineFilterLinkTypes(t *testing.T) {
+	files := make(map[string][]byte)
+	for _, name := range []string{"ethernet", "sll", "sll2", "ipv4", "tcp", "udp"} {
+		data, err := os.ReadFile("layers/testdata/" + name + ".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = data
+	}
+	sll := slices.Clone(files["sll"])
+	// IPv4 instead of IPv6 in the protocol field
+	sll[14], sll[15] = 0x08, 0x00
+	ipv4UDP := slices.Clone(files["ipv4"])
+	ipv4UDP[9] = 17
+	tcp := slices.Concat(files["ipv4"], files["tcp"])
+	newReader := func() *testReader {
+		return &testReader{
+			infos: []capture.Info{
+				{LinkType: capture.LinkTypeEthernet},
+				{LinkType: capture.LinkTypeLinuxSLL},
+				{LinkType: capture.LinkTypeLinuxSLL2},
+				{LinkType: capture.LinkTypeLinuxSLL2},
+			},
+			packets: [][]byte{
+				slices.Concat(files["ethernet"], tcp),
+				slices.Concat(sll, tcp),
+				slices.Concat(files["sll2"], tcp),
+				slices.Concat(files["sll2"], ipv4UDP, files["udp"]),
+			},
+		}
+	}
+	w := &testWriter{}
+	require.NoError(t, OpenOffline(&Config{Expr: "tcp port 443"}, newReader(), w))
+	require.Len(t, w.packets, 3)
+	require.Equal(t, capture.LinkTypeLinuxSLL2, w.infos[2].LinkType)
+
+	// link-layer addresses are not a part of cooked headers
+	w = &testWriter{}
+	require.Error(t, OpenOffline(&Config{Expr: "ether host 02:fc:00:00:00:01"}, newReader(), w))
+}
+
+func TestOpenOfflineFilterRawIP(t *testing.T) {
+	files := make(map[string][]byte)
+	for _, name := range []string{"ipv4", "ipv6", "tcp", "udp"} {
+		data, err := os.ReadFile("layers/testdata/" + name + ".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = data
+	}
+	ipv4UDP := slices.Clone(files["ipv4"])
+	ipv4UDP[9] = 17
+	tcp := slices.Concat(files["ipv4"], files["tcp"])
+	udp := slices.Concat(ipv4UDP, files["udp"])
+	for _, tc := range []struct {
+		linkType int
+		packets  [][]byte
+	}{
+		// IPv6 packet carries TCP segment
+		{capture.LinkTypeRaw, [][]byte{tcp, udp, files["ipv6"]}},
+		{capture.LinkTypeIPv4, [][]byte{tcp, udp}},
+		// AF_INET in little-endian byte order
+		{capture.LinkTypeNull, [][]byte{slices.Concat([]byte{2, 0, 0, 0}, tcp), slices.Concat([]byte{2, 0, 0, 0}, udp)}},
+	} {
+		var buf bytes.Buffer
+		pw := mpcap.NewWriter(&buf)
+		if err := pw.WriteHeaderLinkType(1600, uint32(tc.linkType)); err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range tc.packets {
+			if err := pw.WritePacket(capture.Info{Timestamp: time.Unix(1, 0)}, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for expr, expected := range map[string]int{"tcp port 443": 1, "udp port 53": 1, "host 127.0.0.2": 2, "tcp": len(tc.packets) - 1} {
+			pr, err := mpcap.NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &testWriter{}
+			require.NoError(t, OpenOffline(&Config{Expr: expr}, pr, w))
+			require.Len(t, w.packets, expected, "%s on link type %d", expr, tc.linkType)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+)
+
+var _ PacketWriter = &PDMLWriter{}
+
+// fieldLayouts contains offsets and sizes of fixed header fields of layers,
+// keyed by the lowercase path to the field. Size -1 means the rest of the header.
+// Nested fields share the position of their parent field.
+var fieldLayouts = map[string]map[string][2]int{
+	"ETH": {"dstmac": {0, 6}, "srcmac": {6, 6}, "ethertype": {12, 2}},
+	"SLL": {
+		"packettype": {0, 2}, "arphrdtype": {2, 2}, "addrlen": {4, 2}, "addr": {6, 8}, "protocol": {14, 2},
+	},
+	"SLL2": {
+		"protocol": {0, 2}, "interfaceindex": {4, 4}, "arphrdtype": {8, 2}, "packettype": {10, 1},
+		"addrlen": {11, 1}, "addr": {12, 8},
+	},
+	"IPv4": {
+		"version": {0, 1}, "ihl": {0, 1}, "dscp": {1, 1}, "ecn": {1, 1}, "totallength": {2, 2},
+		"identification": {4, 2}, "flags": {6, 1}, "fragmentoffset": {6, 2}, "ttl": {8, 1}, "protocol": {9, 1},
+		"headerchecksum": {10, 2}, "srcip": {12, 4}, "dstip": {16, 4}, "options": {20, -1},
+	},
+	"IPv6": {
+		"version": {0, 1}, "trafficclass": {0, 2}, "flowlabel": {1, 3}, "payloadlength": {4, 2},
+		"nextheader": {6, 1}, "hoplimit": {7, 1}, "srcip": {8, 16}, "dstip": {24, 16},
+	},
+	"ARP": {
+		"hardwaretype": {0, 2}, "protocoltype": {2, 2}, "hlen": {4, 1}, "plen": {5, 1}, "op": {6, 2},
+		"sendermac": {8, 6}, "senderip": {14, 4}, "targetmac": {18, 6}, "targetip": {24, 4},
+	},
+	"TCP": {
+		"srcport": {0, 2}, "dstport": {2, 2}, "seqnumber": {4, 4}, "acknumber": {8, 4}, "dataoffset": {12, 1},
+		"reserved": {12, 1}, "flags": {13, 1}, "windowsize": {14, 2}, "checksum": {16, 2},
+		"urgentpointer": {18, 2}, "options": {20, -1},
+	},
+	"UDP":    {"srcport": {0, 2}, "dstport": {2, 2}, "udplength": {4, 2}, "checksum": {6, 2}},
+	"ICMP":   {"type": {0, 1}, "code": {1, 1}, "checksum": {2, 2}, "data": {4, -1}},
+	"ICMPv6": {"type": {0, 1}, "code": {1, 1}, "checksum": {2, 2}, "data": {4, -1}},
+	"DNS": {
+		"transactionid": {0, 2}, "flags": {2, 2}, "qdcount": {4, 2}, "ancount": {6, 2}, "nscount": {8, 2},
+		"arcount": {10, 2},
+	},
+}
+
+// PDMLWriter writes decoded packets in Packet Details Markup Language (PDML),
+// the XML format of the full protocol tree produced by tshark -T pdml.
+//
+// Every packet is described by general information and frame pseudo-protocols followed by
+// a proto element for each decoded layer. Exported fields of layer types become field elements
+// named like in FieldsWriter, e.g. ip.src or tcp.flags.syn, with the shown value and
+// the position, size and raw bytes of fixed header fields.
+// Nested structures, like TCP flags or DNS questions, become nested field elements.
+type PDMLWriter struct {
+	w       io.Writer
+	packets uint64
+	buf     bytes.Buffer
+}
+
+// NewPDMLWriter creates a new PDMLWriter.
+func NewPDMLWriter(w io.Writer) *PDMLWriter {
+	return &PDMLWriter{w: w}
+}
+
+// WriteHeader writes the XML declaration and the opening pdml element
+// with the name of the application that created the file.
+func (pw *PDMLWriter) WriteHeader(app string) error {
+	_, err := fmt.Fprintf(pw.w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<pdml version=\"0\" creator=%s>\n", xmlAttr(app))
+	return err
+}
+
+// writeFooter closes the pdml element.
+func (pw *PDMLWriter) writeFooter() {
+	fmt.Fprintln(pw.w, "</pdml>")
+}
+
+// WritePacket decodes the packet and writes it as a packet element.
+//
+// Packets truncated by snaplen are decoded as far as possible.
+func (pw *PDMLWriter) WritePacket(ci capture.Info, data []byte) error {
+	decoded, _, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
+	pw.packets++
+	protocols := make([]string, len(decoded))
+	for i, dl := range decoded {
+		protocols[i] = protoName(dl.name)
+	}
+	length := ci.OriginalLength(data)
+	b := &pw.buf
+	b.Reset()
+	b.WriteString("<packet>\n")
+	fmt.Fprintf(b, "  <proto name=\"geninfo\" pos=\"0\" showname=\"General information\" size=\"%d\">\n", len(data))
+	writeField(b, 2, "num", "Number", fmt.Sprint(pw.packets), 0, len(data), "")
+	writeField(b, 2, "len", "Frame Length", fmt.Sprint(length), 0, len(data), "")
+	writeField(b, 2, "caplen", "Captured Length", fmt.Sprint(len(data)), 0, len(data), "")
+	writeField(b, 2, "timestamp", "Captured Time", ci.Timestamp.Format("Jan _2, 2006 15:04:05.000000000 MST"), 0, len(data), "")
+	b.WriteString("  </proto>\n")
+	fmt.Fprintf(b, "  <proto name=\"frame\" showname=%s size=\"%d\" pos=\"0\">\n",
+		xmlAttr(fmt.Sprintf("Frame %d: %d bytes on wire, %d bytes captured", pw.packets, length, len(data))), len(data))
+	for _, name := range frameFields {
+		value := frameField(name, pw.packets, ci, data, protocols)
+		if value == "" {
+			continue
+		}
+		writeField(b, 2, name, name+": "+value, value, 0, 0, "")
+	}
+	b.WriteString("  </proto>\n")
+	for _, dl := range decoded {
+		fmt.Fprintf(b, "  <proto name=%s showname=%s size=\"%d\" pos=\"%d\">\n",
+			xmlAttr(protoName(dl.name)), xmlAttr(dl.layer.Summary()), dl.size, dl.pos)
+		writeLayerFields(b, 2, reflect.ValueOf(dl.layer).Elem(), dl, "", data)
+		b.WriteString("  </proto>\n")
+	}
+	b.WriteString("</packet>\n")
+	_, err = pw.w.Write(b.Bytes())
+	return err
+}
+
+// writeLayerFields writes exported fields of the struct v belonging to the layer as field elements.
+//
+// The path is the path to v in the layer, empty for the layer itself.
+func writeLayerFields(b *bytes.Buffer, depth int, v reflect.Value, dl decodedLayer, path string, data []byte) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fieldPath := strings.ToLower(sf.Name)
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		name := fieldName(dl.name, fieldPath)
+		pos, size := fieldPosition(dl, fieldPath, data)
+		var value string
+		if size > 0 {
+			value = hex.EncodeToString(data[pos : pos+size])
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j := range fv.Len() {
+				writeLayerField(b, depth, fv.Index(j), dl, sf.Name, name, fieldPath, pos, size, value, data)
+			}
+			continue
+		}
+		writeLayerField(b, depth, fv, dl, sf.Name, name, fieldPath, pos, size, value, data)
+	}
+}
+
+// writeLayerField writes a single value of a layer field, along with its nested fields.
+func writeLayerField(b *bytes.Buffer, depth int, v reflect.Value, dl decodedLayer, label, name, path string, pos, size int, value string, data []byte) {
+	elem := v
+	for elem.Kind() == reflect.Pointer || elem.Kind() == reflect.Interface {
+		if elem.IsNil() {
+			return
+		}
+		elem = elem.Elem()
+	}
+	nested := elem.Kind() == reflect.Struct && hasExportedFields(elem.Type())
+	var show string
+	if _, ok := v.Interface().(fmt.Stringer); ok || !nested {
+		// multi-line descriptions of records are shown by their first line
+		show, _, _ = strings.Cut(formatValue(v), "\n")
+	}
+	if !nested {
+		writeField(b, depth, name, label+": "+show, show, pos, size, value)
+		return
+	}
+	writeFieldStart(b, depth, name, label+": "+show, show, pos, size, value)
+	b.WriteString(">\n")
+	writeLayerFields(b, depth+1, elem, dl, path, data)
+	fmt.Fprintf(b, "%s</field>\n", strings.Repeat("  ", depth))
+}
+
+// fieldPosition returns the position and size of the field at the path in the packet data.
+//
+// Fields with unknown position, e.g. fields of variable-length records, are placed
+// at the start of the layer with zero size.
+func fieldPosition(dl decodedLayer, path string, data []byte) (int, int) {
+	layout := fieldLayouts[dl.name]
+	for {
+		if l, ok := layout[path]; ok {
+			off, size := l[0], l[1]
+			if size < 0 {
+				size = dl.size - off
+			}
+			pos := dl.pos + off
+			size = max(min(size, len(data)-pos), 0)
+			return pos, size
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return dl.pos, 0
+		}
+		path = path[:i]
+	}
+}
+
+// hasExportedFields reports whether the struct type has exported fields.
+func hasExportedFields(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// writeField writes an empty field element.
+func writeField(b *bytes.Buffer, depth int, name, showname, show string, pos, size int, value string) {
+	writeFieldStart(b, depth, name, showname, show, pos, size, value)
+	b.WriteString("/>\n")
+}
+
+// writeFieldStart writes the start of a field element without the closing bracket.
+func writeFieldStart(b *bytes.Buffer, depth int, name, showname, show string, pos, size int, value string) {
+	fmt.Fprintf(b, "%s<field name=%s showname=%s size=\"%d\" pos=\"%d\" show=%s",
+		strings.Repeat("  ", depth), xmlAttr(name), xmlAttr(showname), size, pos, xmlAttr(show))
+	if value != "" {
+		fmt.Fprintf(b, " value=%s", xmlAttr(value))
+	}
+}
+
+// xmlAttr returns the string as a quoted XML attribute value.
+func xmlAttr(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	xml.EscapeText(&sb, []byte(s))
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,119 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+type pdmlField struct {
+	Name     string      `xml:"name,attr"`
+	Show     string      `xml:"show,attr"`
+	Pos      int         `xml:"pos,attr"`
+	Size     int         `xml:"size,attr"`
+	Value    string      `xml:"value,attr"`
+	Children []pdmlField `xml:"field"`
+}
+
+type pdml struct {
+	Creator string `xml:"creator,attr"`
+	Packets []struct {
+		Protos []struct {
+			Name   string      `xml:"name,attr"`
+			Pos    int         `xml:"pos,attr"`
+			Size   int         `xml:"size,attr"`
+			Fields []pdmlField `xml:"field"`
+		} `xml:"proto"`
+	} `xml:"packet"`
+}
+
+func TestPDMLWriter(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(eth, ipv4...)
+	var buf bytes.Buffer
+	w := NewPDMLWriter(&buf)
+	if err := w.WriteHeader("mshark"); err != nil {
+		t.Fatal(err)
+	}
+	ci := capture.Info{Timestamp: time.Unix(1726565870, 0), LinkType: capture.LinkTypeEthernet}
+	if err := w.WritePacket(ci, data); err != nil {
+		t.Fatal(err)
+	}
+	writeFooters([]PacketWriter{w})
+
+	var doc pdml
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, "mshark", doc.Creator)
+	require.Len(t, doc.Packets, 1)
+	protos := doc.Packets[0].Protos
+	require.Len(t, protos, 4)
+	require.Equal(t, "geninfo", protos[0].Name)
+	require.Equal(t, "frame", protos[1].Name)
+
+	require.Equal(t, "eth", protos[2].Name)
+	require.Equal(t, 0, protos[2].Pos)
+	require.Equal(t, 14, protos[2].Size)
+	require.Equal(t, pdmlField{Name: "eth.dst", Show: "7b:13:0b:87:ea:51", Pos: 0, Size: 6, Value: "7b130b87ea51"}, protos[2].Fields[0])
+
+	require.Equal(t, "ip", protos[3].Name)
+	require.Equal(t, 14, protos[3].Pos)
+	fields := make(map[string]pdmlField)
+	for _, f := range protos[3].Fields {
+		fields[f.Name] = f
+	}
+	src := fields["ip.src"]
+	require.Equal(t, 14+12, src.Pos)
+	require.Equal(t, 4, src.Size)
+	require.Equal(t, hex.EncodeToString(data[26:30]), src.Value)
+	// nested fields share the position of their parent
+	flags := fields["ip.flags"]
+	require.NotEmpty(t, flags.Children)
+	require.Equal(t, "ip.flags.reserved", flags.Children[0].Name)
+	require.Equal(t, flags.Pos, flags.Children[0].Pos)
+}
+
+type failingReader struct {
+	testReader
+}
+
+func (r *failingReader) ReadPacket() (capture.Info, []byte, error) {
+	if len(r.packets) == 0 {
+		return capture.Info{}, nil, errors.New("unexpected end of file")
+	}
+	return r.testReader.ReadPacket()
+}
+
+func TestPDMLWriterOfflineError(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewPDMLWriter(&buf)
+	if err := w.WriteHeader("mshark"); err != nil {
+		t.Fatal(err)
+	}
+	r := &failingReader{testReader{infos: []capture.Info{{LinkType: capture.LinkTypeEthernet}}, packets: [][]byte{eth}}}
+	require.Error(t, OpenOffline(&Config{}, r, w))
+	// the document is closed even if reading fails
+	var doc pdml
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	require.Len(t, doc.Packets, 1)
+	require.True(t, bytes.HasSuffix(buf.Bytes(), []byte("</pdml>\n")))
+}
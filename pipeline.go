@@ -0,0 +1,264 @@
+package mshark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/packet"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+)
+
+const (
+	defaultQueueSize     = 4096
+	defaultStatsInterval = time.Minute
+)
+
+// livePacket is a packet read from one of the capture interfaces.
+//
+// The same packet is shared by all writer queues, its buffer is returned
+// to the pool when the last writer has released it.
+//
+// Interface statistics are passed through the same queues, so that they are
+// written in order with packets. Such items have stats and no buffer.
+type livePacket struct {
+	ci    capture.Info
+	data  []byte
+	buf   *[]byte
+	refs  atomic.Int32
+	stats []capture.Stats
+}
+
+// writerQueue runs a PacketWriter in its own goroutine, so that slow writers
+// do not stall reading from the interfaces.
+type writerQueue struct {
+	w       PacketWriter
+	packets chan *livePacket
+}
+
+// pipeline moves packets from capture sources to writer queues.
+//
+// Readers never block on writers: if the queue of a writer is full, the packet
+// is not delivered to that writer and is counted as dropped in userspace.
+type pipeline struct {
+	queues    []*writerQueue
+	pool      sync.Pool
+	count     uint64 // the maximum number of packets to capture, 0 means no limit
+	direction Direction
+	annotate  Annotator
+	captured  atomic.Uint64
+	drops     atomic.Uint64
+	mu        sync.Mutex // serializes queueing of packets from different sources
+	last      time.Time  // the latest timestamp of packets queued so far, guarded by mu
+	cancel    context.CancelFunc
+	errOnce   sync.Once
+	err       error
+}
+
+func newPipeline(conf *Config, cancel context.CancelFunc, pw ...PacketWriter) *pipeline {
+	size := conf.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	p := &pipeline{cancel: cancel, direction: conf.Direction, annotate: conf.Annotate}
+	if conf.PacketCount > 0 {
+		p.count = uint64(conf.PacketCount)
+	}
+	snaplen := conf.Snaplen
+	p.pool.New = func() any {
+		b := make([]byte, snaplen)
+		return &b
+	}
+	for _, w := range pw {
+		p.queues = append(p.queues, &writerQueue{w: w, packets: make(chan *livePacket, size)})
+	}
+	return p
+}
+
+// fail records the first error and stops the capture.
+func (p *pipeline) fail(err error) {
+	p.errOnce.Do(func() {
+		p.err = err
+	})
+	p.cancel()
+}
+
+// release returns the packet buffer to the pool when it is no longer used by any writer.
+func (p *pipeline) release(lp *livePacket) {
+	if lp.refs.Add(-1) == 0 {
+		p.pool.Put(lp.buf)
+	}
+}
+
+// write writes packets from the queue until it is closed. After an error
+// the remaining packets are released without being written.
+func (p *pipeline) write(q *writerQueue) {
+	var failed bool
+	for lp := range q.packets {
+		if lp.stats != nil {
+			if !failed {
+				if err := writeStats(q.w, lp.stats); err != nil {
+					p.fail(err)
+					failed = true
+				}
+			}
+			continue
+		}
+		if !failed {
+			if err := q.w.WritePacket(lp.ci, lp.data); err != nil {
+				p.fail(err)
+				failed = true
+			}
+		}
+		p.release(lp)
+	}
+}
+
+// writeStats queues interface statistics for writers implementing StatsWriter.
+//
+// Periodic statistics are skipped by writers with full queues, while
+// the final ones are waited for.
+func (p *pipeline) writeStats(stats []capture.Stats, wait bool) {
+	if len(stats) == 0 {
+		return
+	}
+	lp := &livePacket{stats: stats}
+	for _, q := range p.queues {
+		if _, ok := q.w.(StatsWriter); !ok {
+			continue
+		}
+		if wait {
+			q.packets <- lp
+			continue
+		}
+		select {
+		case q.packets <- lp:
+		default:
+		}
+	}
+}
+
+// writeStats writes interface statistics to the writer if it implements StatsWriter.
+func writeStats(w PacketWriter, stats []capture.Stats) error {
+	sw, ok := w.(StatsWriter)
+	if !ok {
+		return nil
+	}
+	for _, st := range stats {
+		if err := sw.WriteStats(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsCollector accumulates kernel statistics of capture sources.
+//
+// The kernel resets its counters every time they are read, so the totals
+// since the start of capture are kept here.
+type statsCollector struct {
+	start  time.Time
+	totals []packet.Stats
+}
+
+func newStatsCollector(n int) *statsCollector {
+	return &statsCollector{start: time.Now(), totals: make([]packet.Stats, n)}
+}
+
+// collect reads statistics of the sources and returns the totals of those
+// that were read successfully.
+func (sc *statsCollector) collect(conns []source) []capture.Stats {
+	now := time.Now()
+	stats := make([]capture.Stats, 0, len(conns))
+	for i, c := range conns {
+		st, err := c.stats()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch stats: %v\n", err)
+			continue
+		}
+		total := &sc.totals[i]
+		total.Packets += st.Packets
+		total.Drops += st.Drops
+		total.FreezeQueueCount += st.FreezeQueueCount
+		stats = append(stats, capture.Stats{
+			InterfaceID: i,
+			StartTime:   sc.start,
+			EndTime:     now,
+			Received:    uint64(total.Packets),
+			Dropped:     uint64(total.Drops),
+		})
+	}
+	return stats
+}
+
+// previous records the timestamp of a packet being queued and returns the timestamp
+// of the packet queued before it. It must be called with mu held.
+//
+// Timestamps of packets from different sources are not ordered, so the returned timestamp
+// is never after the given one and the recorded timestamp never goes back.
+func (p *pipeline) previous(ts time.Time) time.Time {
+	last := p.last
+	if ts.After(last) {
+		p.last = ts
+	}
+	if last.After(ts) {
+		return ts
+	}
+	return last
+}
+
+// read reads packets from the source and distributes them among writer queues
+// until the deadline is exceeded, the capture is stopped or an error occurs.
+//
+// Packet data is copied into a pooled buffer, so the source can reuse its own
+// buffer right away.
+func (p *pipeline) read(ctx context.Context, c source, id int) {
+	for {
+		ci, data, err := c.next()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				p.fail(fmt.Errorf("failed to read Ethernet frame: %v", err))
+			}
+			return
+		}
+		if !p.direction.Match(ci.PacketType) {
+			continue
+		}
+		ci.InterfaceID = id
+		n := p.captured.Add(1)
+		if p.count > 0 && n > p.count {
+			return
+		}
+		if p.annotate != nil {
+			ci.Comment = p.annotate(ci, data)
+		}
+		buf := p.pool.Get().(*[]byte)
+		lp := &livePacket{ci: ci, data: (*buf)[:copy(*buf, data)], buf: buf}
+		lp.refs.Store(int32(len(p.queues)) + 1)
+		var dropped bool
+		// queueing under the lock keeps the previous packet the one queued right before this one
+		p.mu.Lock()
+		lp.ci.Previous = p.previous(ci.Timestamp)
+		for _, q := range p.queues {
+			select {
+			case q.packets <- lp:
+			default:
+				p.release(lp)
+				dropped = true
+			}
+		}
+		p.mu.Unlock()
+		p.release(lp)
+		if dropped {
+			p.drops.Add(1)
+		}
+		if p.count > 0 && n == p.count {
+			p.cancel()
+			return
+		}
+	}
+}
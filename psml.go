@@ -0,0 +1,127 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/layers"
+)
+
+var _ PacketWriter = &PSMLWriter{}
+
+// summaryColumns are the columns of packet summaries.
+var summaryColumns = []string{"No.", "Time", "Source", "Destination", "Protocol", "Length", "Info"}
+
+// packetSummary describes a packet in a single line like packet lists of Wireshark do.
+type packetSummary struct {
+	source      string // The address of the sender, taken from the highest layer containing one.
+	destination string // The address of the receiver, taken from the same layer as the source.
+	protocol    string // The name of the highest decoded layer.
+	info        string // The summary of the highest decoded layer.
+}
+
+// summarize returns the summary of a packet with the given decoded layers.
+func summarize(decoded []decodedLayer) packetSummary {
+	var s packetSummary
+	for _, dl := range decoded {
+		switch l := dl.layer.(type) {
+		case *layers.EthernetFrame:
+			s.source, s.destination = l.SrcMAC.String(), l.DstMAC.String()
+		case *layers.LinuxSLL:
+			s.source, s.destination = l.Addr.String(), ""
+		case *layers.LinuxSLL2:
+			s.source, s.destination = l.Addr.String(), ""
+		case *layers.IPv4Packet:
+			s.source, s.destination = l.SrcIP.String(), l.DstIP.String()
+		case *layers.IPv6Packet:
+			s.source, s.destination = l.SrcIP.String(), l.DstIP.String()
+		}
+	}
+	if len(decoded) > 0 {
+		last := decoded[len(decoded)-1]
+		s.protocol = last.name
+		// summaries start with the name of the layer, e.g. "UDP Segment: Src Port: 53 ..."
+		summary := last.layer.Summary()
+		if _, info, ok := strings.Cut(summary, ": "); ok {
+			summary = info
+		}
+		s.info = strings.TrimSpace(summary)
+	}
+	return s
+}
+
+// PSMLWriter writes packet summaries in Packet Summary Markup Language (PSML),
+// the XML format of packet lists produced by tshark -T psml.
+//
+// Every packet is described by the number, the time since the first packet, source
+// and destination addresses, the highest protocol, the length and the summary of the packet.
+type PSMLWriter struct {
+	w       io.Writer
+	packets uint64
+	start   time.Time // the timestamp of the first packet
+	buf     bytes.Buffer
+}
+
+// NewPSMLWriter creates a new PSMLWriter.
+func NewPSMLWriter(w io.Writer) *PSMLWriter {
+	return &PSMLWriter{w: w}
+}
+
+// WriteHeader writes the XML declaration, the opening psml element with the name
+// of the application that created the file and the structure of packet summaries.
+func (pw *PSMLWriter) WriteHeader(app string) error {
+	b := &pw.buf
+	b.Reset()
+	fmt.Fprintf(b, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<psml version=\"0\" creator=%s>\n<structure>\n", xmlAttr(app))
+	for _, column := range summaryColumns {
+		writeSection(b, column)
+	}
+	b.WriteString("</structure>\n\n")
+	_, err := pw.w.Write(b.Bytes())
+	return err
+}
+
+// writeFooter closes the psml element.
+func (pw *PSMLWriter) writeFooter() {
+	fmt.Fprintln(pw.w, "</psml>")
+}
+
+// WritePacket decodes the packet and writes its summary as a packet element.
+//
+// Packets truncated by snaplen are decoded as far as possible.
+func (pw *PSMLWriter) WritePacket(ci capture.Info, data []byte) error {
+	decoded, _, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
+	pw.packets++
+	if pw.packets == 1 {
+		pw.start = ci.Timestamp
+	}
+	s := summarize(decoded)
+	b := &pw.buf
+	b.Reset()
+	b.WriteString("<packet>\n")
+	writeSection(b, fmt.Sprint(pw.packets))
+	writeSection(b, fmt.Sprintf("%.6f", ci.Timestamp.Sub(pw.start).Seconds()))
+	writeSection(b, s.source)
+	writeSection(b, s.destination)
+	writeSection(b, s.protocol)
+	writeSection(b, fmt.Sprint(ci.OriginalLength(data)))
+	writeSection(b, s.info)
+	b.WriteString("</packet>\n\n")
+	_, err = pw.w.Write(b.Bytes())
+	return err
+}
+
+// writeSection writes a section element with the given text.
+func writeSection(b *bytes.Buffer, text string) {
+	b.WriteString("<section>")
+	xml.EscapeText(b, []byte(text))
+	b.WriteString("</section>\n")
+}
@@ -0,0 +1,54 @@
+package mshark
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPSMLWriter(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipv4, err := os.ReadFile("layers/testdata/ipv4.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewPSMLWriter(&buf)
+	if err := w.WriteHeader("mshark"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1726565870, 0)
+	for i, data := range [][]byte{eth, append(eth, ipv4...)} {
+		ci := capture.Info{Timestamp: start.Add(time.Duration(i) * 1500 * time.Microsecond), LinkType: capture.LinkTypeEthernet}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFooters([]PacketWriter{w})
+
+	var doc struct {
+		Structure []string `xml:"structure>section"`
+		Packets   []struct {
+			Sections []string `xml:"section"`
+		} `xml:"packet"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(t, summaryColumns, doc.Structure)
+	require.Len(t, doc.Packets, 2)
+	require.Equal(t, []string{"1", "0.000000", "43:40:8d:28:ca:0b", "7b:13:0b:87:ea:51", "ETH", "14", "Src MAC: 43:40:8d:28:ca:0b -> Dst MAC: 7b:13:0b:87:ea:51"}, doc.Packets[0].Sections)
+	p := doc.Packets[1].Sections
+	require.Equal(t, "2", p[0])
+	require.Equal(t, "0.001500", p[1])
+	require.Equal(t, "IPv4", p[4])
+	require.Contains(t, p[6], "Src IP: "+p[2]+" -> Dst IP: "+p[3])
+}
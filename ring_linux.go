@@ -0,0 +1,248 @@
+package mshark
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/mdlayher/packet"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/native"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultRingSize int           = 32 << 20
+	ringBlockSize   int           = 1 << 20
+	ringFrameSize   int           = 1 << 11
+	ringBlockTmo    uint32        = 50 // milliseconds
+	ringPollTmo     time.Duration = 100 * time.Millisecond
+	blockHdrOffset  int           = 8 // offset of tpacket_hdr_v1 in tpacket_block_desc
+)
+
+var _ source = &ringSource{}
+
+// ringSource reads packets from PACKET_MMAP TPACKET_V3 ring buffer.
+//
+// The kernel fills blocks of the ring with packets and hands them over to
+// userspace, so a single poll(2) may deliver many packets without copying.
+//
+// https://www.kernel.org/doc/Documentation/networking/packet_mmap.txt
+type ringSource struct {
+	mu        sync.Mutex  // guards ring against unmapping while reading
+	closed    atomic.Bool // checked between polls, so that close does not wait for packets
+	fd        int
+	ring      []byte
+	blocks    int
+	block     int                // index of the current block
+	hdr       *unix.TpacketHdrV1 // header of the current block owned by userspace
+	offset    int                // offset of the next packet in the current block
+	remaining uint32             // number of packets left in the current block
+	snaplen   int
+	linkType  int
+	cooked    []byte // buffer for packets with SLL2 headers on "any" interface
+	deadline  time.Time
+}
+
+// listenRing opens a TPACKET_V3 ring buffer on the given interface.
+func listenRing(conf *Config, in *net.Interface, linkType int, filter []bpf.RawInstruction) (*ringSource, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return nil, fmt.Errorf("permission denied (try setting CAP_NET_RAW capability): %v", err)
+		}
+		return nil, fmt.Errorf("failed to listen on %s: %v", in.Name, err)
+	}
+	r := &ringSource{fd: fd, snaplen: conf.Snaplen, linkType: linkType}
+	if linkType == capture.LinkTypeLinuxSLL2 {
+		r.cooked = make([]byte, sll2HeaderLen+conf.Snaplen)
+	}
+	if err := r.setup(conf, in, filter); err != nil {
+		r.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ringSource) setup(conf *Config, in *net.Interface, filter []bpf.RawInstruction) error {
+	if err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("unable to set TPACKET_V3 on %s: %v", in.Name, err)
+	}
+
+	// setting up filter before binding, so that no unfiltered packets get into the ring
+	if len(filter) > 0 {
+		prog := make([]unix.SockFilter, len(filter))
+		for i, ins := range filter {
+			prog[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+		}
+		fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+		if err := unix.SetsockoptSockFprog(r.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog); err != nil {
+			return fmt.Errorf("unable to attach filter on %s: %v", in.Name, err)
+		}
+	}
+
+	// setting up ring
+	size := conf.RingSize
+	if size <= 0 {
+		size = defaultRingSize
+	}
+	r.blocks = max(size/ringBlockSize, 1)
+	req := unix.TpacketReq3{
+		Block_size:     uint32(ringBlockSize),
+		Block_nr:       uint32(r.blocks),
+		Frame_size:     uint32(ringFrameSize),
+		Frame_nr:       uint32(ringBlockSize / ringFrameSize * r.blocks),
+		Retire_blk_tov: ringBlockTmo,
+	}
+	if err := unix.SetsockoptTpacketReq3(r.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("unable to set up ring buffer on %s: %v", in.Name, err)
+	}
+	ring, err := unix.Mmap(r.fd, 0, ringBlockSize*r.blocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("unable to map ring buffer on %s: %v", in.Name, err)
+	}
+	r.ring = ring
+
+	// binding to interface
+	if err := unix.Bind(r.fd, &unix.SockaddrLinklayer{Protocol: htons(uint16(unixEthPAll)), Ifindex: in.Index}); err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", in.Name, err)
+	}
+
+	// setting promisc mode
+	if conf.promisc(in) {
+		mreq := unix.PacketMreq{Ifindex: int32(in.Index), Type: unix.PACKET_MR_PROMISC}
+		if err := unix.SetsockoptPacketMreq(r.fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+			return fmt.Errorf("unable to set promiscuous mode on %s: %v", in.Name, err)
+		}
+	}
+
+	// timeout
+	if conf.Timeout > 0 {
+		r.deadline = time.Now().Add(conf.Timeout)
+	}
+	return nil
+}
+
+func (r *ringSource) next() (capture.Info, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if r.closed.Load() {
+			return capture.Info{}, nil, net.ErrClosed
+		}
+		if r.remaining > 0 {
+			base := r.block*ringBlockSize + r.offset
+			hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.ring[base]))
+			start := base + int(hdr.Mac)
+			data := r.ring[start : start+min(int(hdr.Snaplen), r.snaplen)]
+			ci := capture.Info{
+				Timestamp: time.Unix(int64(hdr.Sec), int64(hdr.Nsec)).UTC(),
+				Length:    int(hdr.Len),
+				LinkType:  r.linkType,
+			}
+			// socket address of the packet follows the aligned tpacket3_hdr
+			raw := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&r.ring[base+tpacketAlign(unix.SizeofTpacket3Hdr)]))
+			ci.PacketType = packetType(raw.Pkttype)
+			if r.cooked != nil {
+				sa := unix.SockaddrLinklayer{
+					Protocol: raw.Protocol,
+					Ifindex:  int(raw.Ifindex),
+					Hatype:   raw.Hatype,
+					Pkttype:  raw.Pkttype,
+					Halen:    raw.Halen,
+					Addr:     raw.Addr,
+				}
+				n := copy(r.cooked[sll2HeaderLen:], data)
+				data, ci.Length = cook(r.cooked, n, int(hdr.Net)-int(hdr.Mac), ci.Length, r.snaplen, &sa)
+			}
+			r.offset += int(hdr.Next_offset)
+			r.remaining--
+			return ci, data, nil
+		}
+		// giving the current block back to the kernel
+		if r.hdr != nil {
+			atomic.StoreUint32(&r.hdr.Block_status, unix.TP_STATUS_KERNEL)
+			r.hdr = nil
+			r.block = (r.block + 1) % r.blocks
+		}
+		hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&r.ring[r.block*ringBlockSize+blockHdrOffset]))
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER != 0 {
+			r.hdr = hdr
+			r.offset = int(hdr.Offset_to_first_pkt)
+			r.remaining = hdr.Num_pkts
+			continue
+		}
+		if err := r.wait(); err != nil {
+			return capture.Info{}, nil, err
+		}
+	}
+}
+
+// wait blocks until the kernel hands over a block, the deadline is exceeded
+// or poll timeout expires to give a chance to close the ring.
+func (r *ringSource) wait() error {
+	timeout := ringPollTmo
+	if !r.deadline.IsZero() {
+		left := time.Until(r.deadline)
+		if left <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timeout = min(timeout, left)
+	}
+	fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR}}
+	if _, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil && !errors.Is(err, unix.EINTR) {
+		return os.NewSyscallError("poll", err)
+	}
+	if fds[0].Revents&unix.POLLERR != 0 {
+		if _, err := unix.GetsockoptInt(r.fd, unix.SOL_SOCKET, unix.SO_ERROR); err != nil {
+			return os.NewSyscallError("getsockopt", err)
+		}
+	}
+	return nil
+}
+
+func (r *ringSource) stats() (*packet.Stats, error) {
+	stats, err := unix.GetsockoptTpacketStatsV3(r.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	return &packet.Stats{
+		Packets:          stats.Packets,
+		Drops:            stats.Drops,
+		FreezeQueueCount: stats.Freeze_q_cnt,
+	}, nil
+}
+
+// close marks the ring as closed before taking the lock, so that a pending next
+// returns after the current poll instead of blocking close until a packet arrives.
+func (r *ringSource) close() error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ring != nil {
+		unix.Munmap(r.ring)
+		r.ring = nil
+	}
+	return unix.Close(r.fd)
+}
+
+// tpacketAlign aligns the offset as TPACKET_ALIGN macro does.
+func tpacketAlign(x int) int {
+	return (x + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+}
+
+// htons converts a short (uint16) from host-to-network byte order.
+func htons(i uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], i)
+	return native.Endian.Uint16(b[:])
+}
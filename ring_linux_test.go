@@ -0,0 +1,43 @@
+package mshark
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingCancelIdle(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("capturing packets requires root privileges")
+	}
+	in, err := InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+	// no packets match the filter, so the ring stays empty
+	conf := Config{
+		Devices:     []*net.Interface{in},
+		Snaplen:     1600,
+		Expr:        "udp port 9 and udp port 10",
+		RingBuffer:  true,
+		StatsOutput: io.Discard,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- OpenLiveContext(ctx, &conf, NewWriter(io.Discard, false))
+	}()
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("capture did not return after cancellation")
+	}
+}
@@ -0,0 +1,176 @@
+package mshark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+)
+
+var (
+	_ PacketWriter = &Rotator{}
+	_ StatsWriter  = &Rotator{}
+)
+
+// RotateConfig describes when Rotator starts a new file and how files are written.
+//
+// Zero values disable the corresponding condition, so the zero RotateConfig
+// writes all packets to a single uncompressed file.
+type RotateConfig struct {
+	FileSize    int64              // Start a new file when the current one reaches this many bytes (after compression).
+	Interval    time.Duration      // Start a new file when packets are this much newer than the first packet in the current one.
+	PacketCount int                // Start a new file after this many packets.
+	MaxFiles    int                // The maximum number of files to keep, the oldest files are removed.
+	Compression compress.Algorithm // The compression of files.
+}
+
+// Enabled reports whether any rotation condition is set.
+func (rc *RotateConfig) Enabled() bool {
+	return rc.FileSize > 0 || rc.Interval > 0 || rc.PacketCount > 0
+}
+
+// Rotator is a PacketWriter that writes packets to a sequence of files.
+//
+// Every file is created by the create function and gets its own header
+// written by the newWriter function, so each of them is a valid capture file.
+type Rotator struct {
+	conf      RotateConfig
+	create    func(seq int) (*os.File, error)
+	newWriter func(w io.Writer) (PacketWriter, error)
+	f         *os.File
+	cw        *countingWriter
+	zw        io.WriteCloser
+	pw        PacketWriter
+	seq       int
+	start     time.Time // the timestamp of the first packet in the current file
+	packets   int       // the number of packets in the current file
+	files     []string
+}
+
+// NewRotator creates a new Rotator and opens the first file.
+//
+// create is called with a sequence number starting at 0 and must return a new file
+// for each of them. newWriter must return a PacketWriter writing to the given io.Writer
+// with the file header already written.
+func NewRotator(conf *RotateConfig, create func(seq int) (*os.File, error), newWriter func(w io.Writer) (PacketWriter, error)) (*Rotator, error) {
+	r := &Rotator{conf: *conf, create: create, newWriter: newWriter}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the next file and removes the oldest ones exceeding the limit.
+//
+// If the file can not be opened, no file is left open.
+func (r *Rotator) open() error {
+	f, err := r.create(r.seq)
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: f}
+	zw, err := compress.NewWriter(cw, r.conf.Compression)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	pw, err := r.newWriter(zw)
+	if err != nil {
+		zw.Close()
+		f.Close()
+		return err
+	}
+	r.f, r.cw, r.zw, r.pw = f, cw, zw, pw
+	r.packets = 0
+	r.files = append(r.files, f.Name())
+	if r.conf.MaxFiles > 0 {
+		for len(r.files) > r.conf.MaxFiles {
+			if err := os.Remove(r.files[0]); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove file: %v", err)
+			}
+			r.files = r.files[1:]
+		}
+	}
+	return nil
+}
+
+// full reports whether the current file must be rotated before writing the given packet.
+func (r *Rotator) full(ci capture.Info) bool {
+	if r.packets == 0 || !r.conf.Enabled() {
+		return false
+	}
+	return (r.conf.FileSize > 0 && r.cw.n >= r.conf.FileSize) ||
+		(r.conf.PacketCount > 0 && r.packets >= r.conf.PacketCount) ||
+		(r.conf.Interval > 0 && ci.Timestamp.Sub(r.start) >= r.conf.Interval)
+}
+
+// WritePacket writes a packet to the current file, starting a new one if needed.
+func (r *Rotator) WritePacket(ci capture.Info, data []byte) error {
+	if r.pw == nil {
+		return fmt.Errorf("no file is open")
+	}
+	if r.full(ci) {
+		if err := r.closeFile(); err != nil {
+			return err
+		}
+		r.seq++
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	if r.packets == 0 {
+		r.start = ci.Timestamp
+	}
+	r.packets++
+	return r.pw.WritePacket(ci, data)
+}
+
+// WriteStats writes interface statistics to the current file
+// if its PacketWriter implements StatsWriter.
+func (r *Rotator) WriteStats(stats capture.Stats) error {
+	if sw, ok := r.pw.(StatsWriter); ok {
+		return sw.WriteStats(stats)
+	}
+	return nil
+}
+
+// closeFile finishes the current file.
+func (r *Rotator) closeFile() error {
+	if w, ok := r.pw.(footerWriter); ok {
+		w.writeFooter()
+	}
+	return r.Close()
+}
+
+// Close flushes compressed data and closes the current file.
+// Closing a Rotator without an open file does nothing.
+func (r *Rotator) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	f, zw := r.f, r.zw
+	r.f, r.cw, r.zw, r.pw = nil, nil, nil, nil
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	return nil
+}
+
+// countingWriter counts bytes written to the underlying io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package mshark
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/shadowy-pycoder/mshark/v2/compress"
+	"github.com/shadowy-pycoder/mshark/v2/mpcap"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRotator(t *testing.T, conf *RotateConfig) (*Rotator, string) {
+	dir := t.TempDir()
+	create := func(seq int) (*os.File, error) {
+		return os.Create(filepath.Join(dir, fmt.Sprintf("%d.pcap", seq)))
+	}
+	newWriter := func(w io.Writer) (PacketWriter, error) {
+		pw := mpcap.NewWriter(w)
+		return pw, pw.WriteHeader(1600)
+	}
+	r, err := NewRotator(conf, create, newWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, dir
+}
+
+// readTestFile returns the data of all packets in the pcap file.
+func readTestFile(t *testing.T, path string) [][]byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := mpcap.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets [][]byte
+	for {
+		_, data, err := r.ReadPacket()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, data)
+	}
+}
+
+func TestRotatorPacketCount(t *testing.T) {
+	r, dir := newTestRotator(t, &RotateConfig{PacketCount: 2, MaxFiles: 2})
+	for i := range 5 {
+		if err := r.WritePacket(capture.Info{Timestamp: time.Unix(int64(i), 0)}, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	files, err := filepath.Glob(filepath.Join(dir, "*.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first file is removed
+	require.Equal(t, []string{filepath.Join(dir, "1.pcap"), filepath.Join(dir, "2.pcap")}, files)
+	require.Equal(t, [][]byte{{2}, {3}}, readTestFile(t, files[0]))
+	require.Equal(t, [][]byte{{4}}, readTestFile(t, files[1]))
+}
+
+func TestRotatorIntervalAndSize(t *testing.T) {
+	r, dir := newTestRotator(t, &RotateConfig{Interval: time.Minute})
+	start := time.Unix(1726565870, 0)
+	for _, ts := range []time.Time{start, start.Add(59 * time.Second), start.Add(time.Minute)} {
+		if err := r.WritePacket(capture.Info{Timestamp: ts}, []byte{0xca, 0xfe}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	require.Len(t, readTestFile(t, filepath.Join(dir, "0.pcap")), 2)
+	require.Len(t, readTestFile(t, filepath.Join(dir, "1.pcap")), 1)
+
+	// pcap header takes 24 bytes and each packet 16+2 bytes
+	r, dir = newTestRotator(t, &RotateConfig{FileSize: 24 + 2*18})
+	for range 3 {
+		if err := r.WritePacket(capture.Info{Timestamp: start}, []byte{0xca, 0xfe}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	require.Len(t, readTestFile(t, filepath.Join(dir, "0.pcap")), 2)
+	require.Len(t, readTestFile(t, filepath.Join(dir, "1.pcap")), 1)
+}
+
+func TestRotatorOpenError(t *testing.T) {
+	dir := t.TempDir()
+	create := func(seq int) (*os.File, error) {
+		return os.Create(filepath.Join(dir, fmt.Sprintf("%d.pcap.gz", seq)))
+	}
+	var files int
+	newWriter := func(w io.Writer) (PacketWriter, error) {
+		files++
+		if files > 1 {
+			return nil, errors.New("failed to write header")
+		}
+		pw := mpcap.NewWriter(w)
+		return pw, pw.WriteHeader(1600)
+	}
+	r, err := NewRotator(&RotateConfig{PacketCount: 1, Compression: compress.Gzip}, create, newWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		err := r.WritePacket(capture.Info{Timestamp: time.Unix(int64(i), 0)}, []byte{byte(i)})
+		if i == 0 {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+	}
+	require.Error(t, r.WritePacket(capture.Info{}, []byte{2}))
+	// the first file is already closed and the compressor of the second one is closed on failure
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	f, err := os.Open(filepath.Join(dir, "1.pcap.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = gzip.NewReader(f)
+	require.NoError(t, err)
+}
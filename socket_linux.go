@@ -0,0 +1,192 @@
+package mshark
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/mdlayher/packet"
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"golang.org/x/sys/unix"
+)
+
+var _ source = &socketSource{}
+
+// socketSource reads packets with one syscall per packet.
+//
+// Packets are received with recvmsg(2) to obtain the time the kernel received
+// them from SO_TIMESTAMPNS control messages. MSG_TRUNC makes recvmsg return
+// the original length of packets truncated by snaplen.
+//
+// On "any" interface link-layer headers are replaced with SLL2 headers,
+// the offset of the network layer is taken from PACKET_AUXDATA control messages.
+type socketSource struct {
+	c        *packet.Conn
+	rc       syscall.RawConn
+	b        []byte
+	oob      []byte
+	snaplen  int
+	linkType int
+	cooked   bool // packets are stored after sll2HeaderLen bytes of headroom in b
+}
+
+// listen opens a connection on the given interface.
+func listen(conf *Config, in *net.Interface, linkType int, packetcfg *packet.Config) (*socketSource, error) {
+	// opening connection
+	c, err := packet.Listen(in, packet.Raw, unixEthPAll, packetcfg)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("permission denied (try setting CAP_NET_RAW capability): %v", err)
+		}
+		return nil, fmt.Errorf("failed to listen on %s: %v", in.Name, err)
+	}
+
+	// setting promisc mode
+	if in.Name != "any" {
+		if err := c.SetPromiscuous(conf.Promisc); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("unable to set promiscuous mode on %s: %v", in.Name, err)
+		}
+	}
+
+	// kernel timestamps
+	rc, err := c.SyscallConn()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %v", in.Name, err)
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+	}); err == nil {
+		err = serr
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("unable to enable timestamps on %s: %v", in.Name, err)
+	}
+
+	// network layer offsets
+	cooked := linkType == capture.LinkTypeLinuxSLL2
+	if cooked {
+		if err := rc.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_PACKET, unix.PACKET_AUXDATA, 1)
+		}); err == nil {
+			err = serr
+		}
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("unable to enable auxiliary data on %s: %v", in.Name, err)
+		}
+	}
+
+	// timeout
+	if conf.Timeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(conf.Timeout)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("unable to set timeout on %s: %v", in.Name, err)
+		}
+	}
+	s := &socketSource{
+		c:        c,
+		rc:       rc,
+		b:        make([]byte, conf.Snaplen),
+		oob:      make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{})))+unix.CmsgSpace(int(unsafe.Sizeof(unix.TpacketAuxdata{})))),
+		snaplen:  conf.Snaplen,
+		linkType: linkType,
+		cooked:   cooked,
+	}
+	if cooked {
+		s.b = make([]byte, sll2HeaderLen+conf.Snaplen)
+	}
+	return s, nil
+}
+
+func (s *socketSource) next() (capture.Info, []byte, error) {
+	var (
+		n, oobn int
+		from    unix.Sockaddr
+		rerr    error
+	)
+	b := s.b
+	if s.cooked {
+		b = s.b[sll2HeaderLen:]
+	}
+	// reading through RawConn keeps the deadline and closing of packet.Conn working
+	err := s.rc.Read(func(fd uintptr) bool {
+		n, oobn, _, from, rerr = unix.Recvmsg(int(fd), b, s.oob, unix.MSG_TRUNC)
+		return rerr != unix.EAGAIN && rerr != unix.EINTR
+	})
+	if err == nil && rerr != nil {
+		err = os.NewSyscallError("recvmsg", rerr)
+	}
+	if err != nil {
+		return capture.Info{}, nil, err
+	}
+	timestamp, netOffset := s.parseControl(s.oob[:oobn])
+	ci := capture.Info{Timestamp: timestamp, Length: n, LinkType: s.linkType}
+	data := b[:min(n, len(b))]
+	if sa, ok := from.(*unix.SockaddrLinklayer); ok {
+		ci.PacketType = packetType(sa.Pkttype)
+		if s.cooked {
+			data, ci.Length = cook(s.b, len(data), netOffset, n, s.snaplen, sa)
+		}
+	}
+	return ci, data, nil
+}
+
+// packetType converts Linux packet type (PACKET_* constants in linux/if_packet.h) to capture.PacketType.
+//
+// PACKET_LOOPBACK is the looped back copy of multicast packets sent by the host, so it is outgoing.
+func packetType(pkttype uint8) capture.PacketType {
+	switch pkttype {
+	case unix.PACKET_HOST:
+		return capture.PacketTypeHost
+	case unix.PACKET_BROADCAST:
+		return capture.PacketTypeBroadcast
+	case unix.PACKET_MULTICAST:
+		return capture.PacketTypeMulticast
+	case unix.PACKET_OTHERHOST:
+		return capture.PacketTypeOtherHost
+	case unix.PACKET_OUTGOING, unix.PACKET_LOOPBACK:
+		return capture.PacketTypeOutgoing
+	default:
+		return capture.PacketTypeUnknown
+	}
+}
+
+// parseControl returns the time from SCM_TIMESTAMPNS control message or the current time
+// if the kernel did not provide one, and the offset of the network layer from PACKET_AUXDATA.
+func (s *socketSource) parseControl(oob []byte) (time.Time, int) {
+	timestamp := time.Now().UTC()
+	var netOffset int
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return timestamp, netOffset
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPNS &&
+			len(m.Data) >= int(unsafe.Sizeof(unix.Timespec{})):
+			ts := (*unix.Timespec)(unsafe.Pointer(&m.Data[0]))
+			timestamp = time.Unix(ts.Unix()).UTC()
+		case m.Header.Level == unix.SOL_PACKET && m.Header.Type == unix.PACKET_AUXDATA &&
+			len(m.Data) >= int(unsafe.Sizeof(unix.TpacketAuxdata{})):
+			aux := (*unix.TpacketAuxdata)(unsafe.Pointer(&m.Data[0]))
+			netOffset = int(aux.Net)
+		}
+	}
+	return timestamp, netOffset
+}
+
+func (s *socketSource) stats() (*packet.Stats, error) {
+	return s.c.Stats()
+}
+
+func (s *socketSource) close() error {
+	return s.c.Close()
+}
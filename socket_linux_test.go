@@ -0,0 +1,31 @@
+package mshark
+
+import (
+	"testing"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPacketTypeDirection(t *testing.T) {
+	for _, tt := range []struct {
+		pkttype  uint8
+		expected capture.PacketType
+		in, out  bool
+	}{
+		{unix.PACKET_HOST, capture.PacketTypeHost, true, false},
+		{unix.PACKET_BROADCAST, capture.PacketTypeBroadcast, true, false},
+		{unix.PACKET_MULTICAST, capture.PacketTypeMulticast, true, false},
+		{unix.PACKET_OTHERHOST, capture.PacketTypeOtherHost, true, false},
+		{unix.PACKET_OUTGOING, capture.PacketTypeOutgoing, false, true},
+		{unix.PACKET_LOOPBACK, capture.PacketTypeOutgoing, false, true},
+		{unix.PACKET_KERNEL, capture.PacketTypeUnknown, false, false},
+	} {
+		pt := packetType(tt.pkttype)
+		require.Equal(t, tt.expected, pt)
+		require.Equal(t, tt.in, DirectionIn.Match(pt))
+		require.Equal(t, tt.out, DirectionOut.Match(pt))
+		require.True(t, DirectionInOut.Match(pt))
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package mshark
+
+import (
+	"errors"
+	"net"
+
+	"github.com/mdlayher/packet"
+	"golang.org/x/net/bpf"
+)
+
+// listen is only supported on Linux.
+func listen(_ *Config, _ *net.Interface, _ int, _ *packet.Config) (source, error) {
+	return nil, errors.New("live capture is only supported on Linux")
+}
+
+// listenRing is only supported on Linux.
+func listenRing(_ *Config, _ *net.Interface, _ int, _ []bpf.RawInstruction) (source, error) {
+	return nil, errors.New("ring buffer capture is only supported on Linux")
+}
+
+// interfaceLinkType is only supported on Linux.
+func interfaceLinkType(_ *net.Interface) (int, error) {
+	return 0, errors.New("live capture is only supported on Linux")
+}
@@ -0,0 +1,97 @@
+package mshark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+)
+
+var _ PacketWriter = &SummaryWriter{}
+
+// summaryFormat is the format of summary table rows, one verb per column of summaryColumns.
+// Columns are padded to fit most addresses and protocol names, longer values shift the rest of the row.
+// The width of the time column depends on the timestamp format.
+const summaryFormat = "%6s %*s %-17s %-17s %-8s %6s %s\n"
+
+// SummaryWriter writes decoded packets as a table with a single line per packet,
+// like packet lists of Wireshark and tshark do:
+//
+//	No.         Time Source            Destination       Protocol Length Info
+//	  1     0.000000 192.168.1.10      8.8.8.8           DNS          71 ...
+//	  2     0.012731 8.8.8.8           192.168.1.10      DNS          87 ...
+//
+// Time is the number of seconds since the first packet unless another timestamp format is set,
+// and the protocol and info are taken from the highest decoded layer.
+type SummaryWriter struct {
+	w       io.Writer
+	packets uint64
+	clock   packetClock
+	hexDump bool
+	buf     bytes.Buffer
+}
+
+// NewSummaryWriter creates a new SummaryWriter.
+func NewSummaryWriter(w io.Writer) *SummaryWriter {
+	return &SummaryWriter{w: w, clock: packetClock{format: TimestampRelative}}
+}
+
+// SetTimestampFormat sets how timestamps of packets are displayed. Defaults to TimestampRelative.
+func (sw *SummaryWriter) SetTimestampFormat(f TimestampFormat) {
+	sw.clock.format = f
+}
+
+// SetHexDump enables hex and ASCII dumps of whole packets after their summaries.
+func (sw *SummaryWriter) SetHexDump(enabled bool) {
+	sw.hexDump = enabled
+}
+
+// WriteHeader writes the names of the columns.
+func (sw *SummaryWriter) WriteHeader() error {
+	columns := make([]any, 0, len(summaryColumns)+1)
+	for i, column := range summaryColumns {
+		if i == 1 {
+			columns = append(columns, sw.clock.format.width())
+		}
+		columns = append(columns, column)
+	}
+	_, err := fmt.Fprintf(sw.w, summaryFormat, columns...)
+	return err
+}
+
+// WritePacket decodes the packet and writes its summary as a single line.
+//
+// Packets truncated by snaplen are decoded as far as possible and marked as such.
+func (sw *SummaryWriter) WritePacket(ci capture.Info, data []byte) error {
+	decoded, truncated, err := decodeLayers(ci, data)
+	if err != nil {
+		return err
+	}
+	sw.packets++
+	s := summarize(decoded)
+	if truncated != "" {
+		s.info += " [truncated]"
+	}
+	sw.buf.Reset()
+	fmt.Fprintf(&sw.buf, summaryFormat,
+		fmt.Sprint(sw.packets),
+		sw.clock.format.width(),
+		sw.clock.timestamp(ci),
+		s.source,
+		s.destination,
+		s.protocol,
+		fmt.Sprint(ci.OriginalLength(data)),
+		s.info,
+	)
+	if sw.hexDump {
+		writeHexDump(&sw.buf, data, layerRanges(decoded), false)
+	}
+	_, err = sw.w.Write(sw.buf.Bytes())
+	return err
+}
+
+// writeFooter writes the number of packets written by the writer.
+func (sw *SummaryWriter) writeFooter() {
+	fmt.Fprintf(sw.w, "- Packets Captured: %d\n", sw.packets)
+}
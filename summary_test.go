@@ -0,0 +1,39 @@
+package mshark
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shadowy-pycoder/mshark/v2/capture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryWriter(t *testing.T) {
+	eth, err := os.ReadFile("layers/testdata/ethernet.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewSummaryWriter(&buf)
+	if err := w.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1726565870, 0)
+	for i := range 2 {
+		ci := capture.Info{Timestamp: start.Add(time.Duration(i) * 1500 * time.Microsecond), LinkType: capture.LinkTypeEthernet}
+		if err := w.WritePacket(ci, eth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFooters([]PacketWriter{w})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, summaryColumns, strings.Fields(lines[0]))
+	require.Equal(t, "     1     0.000000 43:40:8d:28:ca:0b 7b:13:0b:87:ea:51 ETH          14 Src MAC: 43:40:8d:28:ca:0b -> Dst MAC: 7b:13:0b:87:ea:51", lines[1])
+	require.True(t, strings.HasPrefix(lines[2], "     2     0.001500 "), lines[2])
+	require.Equal(t, "- Packets Captured: 2", lines[3])
+}